@@ -4,9 +4,12 @@ import (
 	cfg "ImageCutter/pkg/config"
 	logging "ImageCutter/pkg/logger"
 	"ImageCutter/pkg/services/cutter"
+	"context"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 )
 
 func main() {
@@ -22,6 +25,8 @@ func main() {
 	envCacheSize := os.Getenv("CACHESIZE")
 	envCacheClean := os.Getenv("CACHECLEAN")
 	envCacheFolder := os.Getenv("CACHEFOLDER")
+	envAllowPrivate := os.Getenv("ALLOWPRIVATE")
+	envAdminToken := os.Getenv("ADMINTOKEN")
 
 	// Replace config settings by env settings if they are not nil
 	if envPort != "" {
@@ -32,22 +37,43 @@ func main() {
 		config.Cutter.Port = port
 	}
 	if envCacheSize != "" {
-		cacheSize, err := strconv.ParseInt(envCacheSize, 10, 64)
+		cacheSize, err := cfg.ParseSize(envCacheSize)
 		if err != nil {
-			log.Fatalf("Cannot convert env var CACHESIZE: %v to int, err: %v", cacheSize, err)
+			log.Fatalf("Cannot parse env var CACHESIZE: %v, err: %v", envCacheSize, err)
 		}
 		config.Cutter.Cache.Size = cacheSize
 	}
 	if envCacheClean != "" {
-		cacheClean, err := strconv.Atoi(envCacheClean)
+		cacheClean, err := cfg.ParseDuration(envCacheClean)
 		if err != nil {
-			log.Fatalf("Cannot convert env var CACHECLEAN: %v to int, err: %v", envCacheClean, err)
+			log.Fatalf("Cannot parse env var CACHECLEAN: %v, err: %v", envCacheClean, err)
 		}
 		config.Cutter.Cache.CleanInterval = cacheClean
 	}
 	if envCacheFolder != ""{
 		config.Cutter.Cache.Folder = envCacheFolder
 	}
+	if envAllowPrivate != "" {
+		allowPrivate, err := strconv.ParseBool(envAllowPrivate)
+		if err != nil {
+			log.Fatalf("Cannot convert env var ALLOWPRIVATE: %v to bool, err: %v", envAllowPrivate, err)
+		}
+		config.Cutter.Origin.AllowPrivate = allowPrivate
+	}
+	if envAdminToken != "" {
+		config.Cutter.Admin.Token = envAdminToken
+	}
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Cutter config is invalid: %v", err)
+	}
+
+	// Cache snapshot commands
+	if len(os.Args) > 1 {
+		if err := snapshotCommand(config, os.Args[1:]); err != nil {
+			log.Fatalf("Command %v give error: %v", os.Args[1], err)
+		}
+		return
+	}
 
 
 	// Create logger
@@ -63,5 +89,29 @@ func main() {
 	}
 
 	// Start cutter listener
-	cutterService.Start()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- cutterService.Start()
+	}()
+
+	// Wait for termination signal and drain in-flight requests
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		cutterService.Close()
+		if err != nil {
+			logger.Sugar().Fatalf("Cutter service give error: %v", err)
+		}
+		return
+	case sig := <-signals:
+		logger.Sugar().Infof("Received %v signal", sig)
+	}
+	serverConfig := config.Cutter.Server
+	ctx, cancel := context.WithTimeout(context.Background(), serverConfig.DrainDelay.Duration()+serverConfig.DrainTimeout.Duration())
+	defer cancel()
+	if err := cutterService.Shutdown(ctx); err != nil {
+		logger.Sugar().Errorf("Cutter service was not stopped gracefully: %v", err)
+	}
+	_ = logger.Sync()
 }
\ No newline at end of file
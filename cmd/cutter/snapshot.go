@@ -0,0 +1,102 @@
+package main
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// snapshotCommand moves cache between running cutter services through admin API,
+// admin token is taken from config:
+//
+//	cutter export [-addr http://localhost:5005] cache.tar
+//	cutter import [-addr http://localhost:5005] cache.tar
+func snapshotCommand(config *cfg.CutterConfig, args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	addr := flags.String("addr", fmt.Sprintf("http://localhost:%v", config.Cutter.Port), "cutter service address")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: cutter %v [-addr address] file.tar", args[0])
+	}
+	url := fmt.Sprintf("%v/admin/cache/snapshot", *addr)
+
+	token := config.Cutter.Admin.Token
+	switch args[0] {
+	case "export":
+		return exportSnapshot(url, token, flags.Arg(0))
+	case "import":
+		return importSnapshot(url, token, flags.Arg(0))
+	}
+	return fmt.Errorf("unknown command: %v", args[0])
+}
+
+func exportSnapshot(url string, token string, path string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(file, resp.Body)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Cache snapshot (%v bytes) was saved to %v\n", size, path)
+	return nil
+}
+
+func importSnapshot(url string, token string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	req, err := http.NewRequest(http.MethodPost, url, file)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Cache snapshot %v was imported: %s", path, body)
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("cutter service return %v: %s", resp.StatusCode, body)
+}
@@ -1,6 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 	"log"
 )
@@ -11,36 +18,382 @@ type Logger struct {
 	ErrorOutputPaths []string `mapstructure:"errorOutputPaths"`
 }
 type Cache struct {
-	Size            int64   `mapstructure:"size"`
+	Size            ByteSize   `mapstructure:"size"`
 	Folder         string   `mapstructure:"folder"`
-	CleanInterval int `mapstructure:"cleantime"`
+	CleanInterval Duration `mapstructure:"cleantime"`
+	CleanJitter Duration `mapstructure:"cleanjitter"`
+	Watermark float64 `mapstructure:"watermark"`
+	Webhook Webhook `mapstructure:"webhook"`
+}
+// Webhook receives cache events with POST requests. Disabled if url is empty
+type Webhook struct {
+	Url     string   `mapstructure:"url"`
+	Timeout Duration `mapstructure:"timeout"`
+	Queue   int      `mapstructure:"queue"`
+}
+
+// Origin configures HTTP client which fetches images from remote servers
+type Origin struct {
+	ConnectTimeout Duration `mapstructure:"connecttimeout"`
+	TLSTimeout     Duration `mapstructure:"tlstimeout"`
+	HeaderTimeout  Duration `mapstructure:"headertimeout"`
+	Timeout        Duration `mapstructure:"timeout"` // whole request including body
+	MaxSize        ByteSize `mapstructure:"maxsize"`
+	MaxRedirects   int      `mapstructure:"maxredirects"`
+	UserAgent      string   `mapstructure:"useragent"`
+	Schemes        []string `mapstructure:"schemes"`      // allowed url schemes
+	AllowHosts     []string `mapstructure:"allowhosts"`   // e.g. "cdn.example.com", "*.example.com". Empty list allows all hosts
+	DenyHosts      []string `mapstructure:"denyhosts"`    // checked before allowhosts
+	AllowPrivate   bool     `mapstructure:"allowprivate"` // allow loopback, link-local and private network addresses
+	AllowRawUrls   bool     `mapstructure:"allowrawurls"` // allow full remote urls in /crop requests besides named sources
+	ForwardHeaders []string `mapstructure:"forwardheaders"` // client headers passed to remote server, they are part of cache key
+	ForwardHosts   []string `mapstructure:"forwardhosts"`   // raw url hosts which get forwardheaders, e.g. "*.example.com". Named sources always get them
+	ProxyHeaders   []string `mapstructure:"proxyheaders"`   // remote server headers copied to /crop responses, e.g. "Cache-Control", "X-*"
+	Retries          int      `mapstructure:"retries"`          // retries of connection errors and 502, 503, 504 codes
+	RetryBackoff     Duration `mapstructure:"retrybackoff"`     // first retry delay, doubled for every next retry
+	RetryMaxBackoff  Duration `mapstructure:"retrymaxbackoff"`
+	BreakerThreshold int      `mapstructure:"breakerthreshold"` // consecutive failures which open host circuit breaker, 0 disables breaker
+	BreakerCooldown  Duration `mapstructure:"breakercooldown"`  // open breaker lets one probe request through after cooldown
+	RateLimit        float64  `mapstructure:"ratelimit"`        // fetches per second from one host, 0 disables limit
+	RateBurst        int      `mapstructure:"rateburst"`
+	MaxConcurrent    int      `mapstructure:"maxconcurrent"`    // fetches in flight from one host, 0 disables limit
+	QueueTimeout     Duration `mapstructure:"queuetimeout"`     // wait for rate and concurrency limits before 503
+}
+
+// Cropper limits are checked with image header before decoding. Zero disables limit
+type Cropper struct {
+	MaxInputWidth  int     `mapstructure:"maxinputwidth"`
+	MaxInputHeight int     `mapstructure:"maxinputheight"`
+	MaxMegapixels  float64 `mapstructure:"maxmegapixels"` // width * height of original in millions of pixels
+	MaxWidth       int     `mapstructure:"maxwidth"`      // of cropped image
+	MaxHeight      int     `mapstructure:"maxheight"`
+}
+
+// Server configures HTTP server of cutter
+type Server struct {
+	ReadTimeout  Duration `mapstructure:"readtimeout"`
+	WriteTimeout Duration `mapstructure:"writetimeout"`
+	IdleTimeout  Duration `mapstructure:"idletimeout"`
+	DrainDelay   Duration `mapstructure:"draindelay"`   // readiness check fails before listener is closed on shutdown
+	DrainTimeout Duration `mapstructure:"draintimeout"` // wait for in-flight requests on shutdown
+}
+
+// Limits protect service from bursts of requests
+type Limits struct {
+	MaxCrops         int      `mapstructure:"maxcrops"`         // crops in progress, default is number of CPUs, 0 disables limit
+	CropQueue        int      `mapstructure:"cropqueue"`        // crops waiting for free slot, 503 is returned if queue is full
+	CropQueueTimeout Duration `mapstructure:"cropqueuetimeout"` // wait for free slot before 503
+	ClientRate       float64  `mapstructure:"clientrate"`       // requests per second from one client IP, 0 disables limit
+	ClientBurst      int      `mapstructure:"clientburst"`
+	TrustForwarded   bool     `mapstructure:"trustforwarded"`   // client IP is last address of X-Forwarded-For, enable only behind reverse proxy
+}
+
+// Cors allows browser clients from other origins. Disabled if allowed origins are empty
+type Cors struct {
+	AllowOrigins     []string `mapstructure:"alloworigins"` // e.g. "https://editor.example.com", "https://*.example.com" or "*"
+	AllowMethods     []string `mapstructure:"allowmethods"`
+	AllowHeaders     []string `mapstructure:"allowheaders"` // request headers besides CORS-safelisted ones, "*" allows all
+	ExposeHeaders    []string `mapstructure:"exposeheaders"` // response headers readable by scripts
+	MaxAge           Duration `mapstructure:"maxage"`        // of preflight response in browser cache
+	AllowCredentials bool     `mapstructure:"allowcredentials"`
+}
+
+// Health configures /readyz checks
+type Health struct {
+	ProbeUrl     string   `mapstructure:"probeurl"` // remote url checked by HEAD request, disabled if empty
+	ProbeTimeout Duration `mapstructure:"probetimeout"`
+}
+
+// Response configures /crop responses
+type Response struct {
+	CacheControl string `mapstructure:"cachecontrol"` // used if Cache-Control of remote server is not proxied
+}
+
+// Signing requires HMAC signature in /crop urls. Disabled if keys are empty
+type Signing struct {
+	Keys []string `mapstructure:"keys"` // all keys are accepted, so new key can be added before old one is removed
+}
+
+// Admin protects /admin routes with its own credential. Routes are not registered if token is empty
+type Admin struct {
+	Token           string   `mapstructure:"token"`           // sent as "Authorization: Bearer <token>"
+	SnapshotTimeout Duration `mapstructure:"snapshottimeout"` // replaces Server read and write timeouts for snapshot export and import, 0 disables it
+}
+
+// Operations of requests. API keys may be allowed crop and cache, admin routes need Admin token
+const (
+	OperationCrop  = "crop"  // /crop
+	OperationCache = "cache" // /cache
+	OperationAdmin = "admin" // /admin
+)
+
+// APIKey is credential of internal consumer with its own limits
+type APIKey struct {
+	Key        string   `mapstructure:"key"`
+	Sources    []string `mapstructure:"sources"`    // allowed named sources, empty list allows all
+	RawUrls    bool     `mapstructure:"rawurls"`    // allow full remote urls if Origin.allowrawurls is set
+	MaxWidth   int      `mapstructure:"maxwidth"`   // of cropped image, 0 means Cropper limit
+	MaxHeight  int      `mapstructure:"maxheight"`
+	Operations []string `mapstructure:"operations"` // empty list allows crop and cache
+	RateLimit  float64  `mapstructure:"ratelimit"`  // requests per second, 0 disables limit
+	RateBurst  int      `mapstructure:"rateburst"`
+}
+
+// Auth requires API key in /crop and /cache requests. Disabled if keys are empty
+type Auth struct {
+	Header     string            `mapstructure:"header"`
+	QueryParam string            `mapstructure:"queryparam"`
+	Keys       map[string]APIKey `mapstructure:"keys"` // by consumer name
+}
+
+// Tracing exporters
+const (
+	TracingStdout = "stdout"
+	TracingOTLP   = "otlp"
+)
+
+// Tracing configures export of request spans
+type Tracing struct {
+	Exporter    string  `mapstructure:"exporter"` // "" (disabled), "stdout" or "otlp"
+	Endpoint    string  `mapstructure:"endpoint"` // OTLP/HTTP traces url, e.g. http://collector:4318/v1/traces
+	ServiceName string  `mapstructure:"servicename"`
+	SampleRatio float64 `mapstructure:"sampleratio"` // part of traces which are exported, not sampled traceparent of client is not exported
+	TrustParent bool    `mapstructure:"trustparent"` // export traces with sampled traceparent of client regardless of sampleratio
+	QueueSize   int     `mapstructure:"queue"` // finished spans waiting for export, new spans are dropped if queue is full
+	Timeout     Duration `mapstructure:"timeout"` // of OTLP request
+}
+
+// Source types
+const (
+	SourceHTTP = "http"
+	SourceFile = "file"
+)
+
+// Source is named remote server or local folder. Image path after source name is appended to source url or root:
+// /crop/300/200/catalog/shoes/1.jpg -> https://assets.internal/products/shoes/1.jpg
+type Source struct {
+	Type           string            `mapstructure:"type"` // "http" (default) or "file"
+	Url            string            `mapstructure:"url"`  // for http sources
+	Root           string            `mapstructure:"root"` // for file sources
+	Headers        map[string]string `mapstructure:"headers"` // sent with every request to source
+	ForwardHeaders []string          `mapstructure:"forwardheaders"` // client headers passed to source in addition to Origin ones
+	ConnectTimeout Duration          `mapstructure:"connecttimeout"` // zero means Origin value
+	HeaderTimeout  Duration          `mapstructure:"headertimeout"`
+	Timeout        Duration          `mapstructure:"timeout"`
+	NoCache        bool              `mapstructure:"nocache"` // do not keep originals in cache
 }
 
 type CutterConfig struct {
 	Cutter struct {
 		Port   int `mapstructure:"Port"`
+		Server Server `mapstructure:"Server"`
 		Cache Cache `mapstructure:"Cache"`
+		Origin Origin `mapstructure:"Origin"`
+		Sources map[string]Source `mapstructure:"Sources"`
+		Cropper Cropper `mapstructure:"Cropper"`
+		Limits Limits `mapstructure:"Limits"`
+		Response Response `mapstructure:"Response"`
+		Signing Signing `mapstructure:"Signing"`
+		Auth Auth `mapstructure:"Auth"`
+		Admin Admin `mapstructure:"Admin"`
+		Cors Cors `mapstructure:"Cors"`
+		Health Health `mapstructure:"Health"`
+		Tracing Tracing `mapstructure:"Tracing"`
 		Logger Logger `mapstructure:"Logger"`
 	} `mapstructure:"Cutter"`
 }
 
+var sourceName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
 func ReadConfig() (conf *CutterConfig, err error) {
 	viper.SetConfigName("cutter")
 	viper.AddConfigPath("configs")
 	viper.AddConfigPath("../../configs")
 	viper.AddConfigPath("../configs")
 	viper.AddConfigPath(".")
+	viper.SetDefault("Cutter.Server.readtimeout", "15s")
+	viper.SetDefault("Cutter.Server.writetimeout", "60s")
+	viper.SetDefault("Cutter.Server.idletimeout", "120s")
+	viper.SetDefault("Cutter.Server.draindelay", "5s")
+	viper.SetDefault("Cutter.Server.draintimeout", "20s")
+	viper.SetDefault("Cutter.Cache.watermark", 0.8)
+	viper.SetDefault("Cutter.Cache.webhook.timeout", "5s")
+	viper.SetDefault("Cutter.Cache.webhook.queue", 100)
+	viper.SetDefault("Cutter.Origin.connecttimeout", "5s")
+	viper.SetDefault("Cutter.Origin.tlstimeout", "5s")
+	viper.SetDefault("Cutter.Origin.headertimeout", "10s")
+	viper.SetDefault("Cutter.Origin.timeout", "30s")
+	viper.SetDefault("Cutter.Origin.maxsize", "20MiB")
+	viper.SetDefault("Cutter.Origin.maxredirects", 5)
+	viper.SetDefault("Cutter.Origin.useragent", "ImageCutter/1.0")
+	viper.SetDefault("Cutter.Origin.schemes", []string{"http", "https"})
+	viper.SetDefault("Cutter.Origin.allowrawurls", true)
+	viper.SetDefault("Cutter.Origin.proxyheaders", []string{"Cache-Control"})
+	viper.SetDefault("Cutter.Origin.retries", 2)
+	viper.SetDefault("Cutter.Origin.retrybackoff", "200ms")
+	viper.SetDefault("Cutter.Origin.retrymaxbackoff", "2s")
+	viper.SetDefault("Cutter.Origin.breakerthreshold", 5)
+	viper.SetDefault("Cutter.Origin.breakercooldown", "30s")
+	viper.SetDefault("Cutter.Origin.ratelimit", 20)
+	viper.SetDefault("Cutter.Origin.rateburst", 40)
+	viper.SetDefault("Cutter.Origin.maxconcurrent", 10)
+	viper.SetDefault("Cutter.Origin.queuetimeout", "2s")
+	viper.SetDefault("Cutter.Cropper.maxinputwidth", 10000)
+	viper.SetDefault("Cutter.Cropper.maxinputheight", 10000)
+	viper.SetDefault("Cutter.Cropper.maxmegapixels", 50)
+	viper.SetDefault("Cutter.Cropper.maxwidth", 4000)
+	viper.SetDefault("Cutter.Cropper.maxheight", 4000)
+	viper.SetDefault("Cutter.Limits.maxcrops", runtime.NumCPU())
+	viper.SetDefault("Cutter.Limits.cropqueue", 64)
+	viper.SetDefault("Cutter.Limits.cropqueuetimeout", "5s")
+	viper.SetDefault("Cutter.Limits.clientrate", 50)
+	viper.SetDefault("Cutter.Limits.clientburst", 100)
+	viper.SetDefault("Cutter.Response.cachecontrol", "public, max-age=86400")
+	viper.SetDefault("Cutter.Health.probetimeout", "2s")
+	viper.SetDefault("Cutter.Cors.allowmethods", []string{"GET", "HEAD"})
+	viper.SetDefault("Cutter.Cors.exposeheaders", []string{"ETag", "Retry-After", "X-Request-ID"})
+	viper.SetDefault("Cutter.Cors.maxage", "10m")
+	viper.SetDefault("Cutter.Admin.snapshottimeout", "30m")
+	viper.SetDefault("Cutter.Auth.header", "X-API-Key")
+	viper.SetDefault("Cutter.Auth.queryparam", "api_key")
+	viper.SetDefault("Cutter.Tracing.servicename", "image-cutter")
+	viper.SetDefault("Cutter.Tracing.sampleratio", 1)
+	viper.SetDefault("Cutter.Tracing.queue", 2048)
+	viper.SetDefault("Cutter.Tracing.timeout", "5s")
 	err = viper.ReadInConfig()
 	if err != nil {
 		log.Printf("Reading cutter config error: %v \n", err)
 		return nil, err
 	}
 
-	err = viper.Unmarshal(&conf)
+	err = viper.Unmarshal(&conf, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		unitsHook,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)))
 	if err != nil {
 		log.Printf("Unmarshaling cutter config error: %v \n", err)
 		return nil, err
 	}
 
 	return conf, nil
+}
+
+// Validate checks config values which cannot be checked while unmarshaling
+func (conf *CutterConfig) Validate() error {
+	if conf.Cutter.Cache.Size <= 0 {
+		return fmt.Errorf("Cutter.Cache.size: must be positive, given: %v", conf.Cutter.Cache.Size)
+	}
+	if conf.Cutter.Cache.CleanInterval <= 0 {
+		return fmt.Errorf("Cutter.Cache.cleantime: must be positive, given: %v", conf.Cutter.Cache.CleanInterval)
+	}
+	if conf.Cutter.Cache.CleanJitter >= conf.Cutter.Cache.CleanInterval {
+		return fmt.Errorf("Cutter.Cache.cleanjitter: must be less than cleantime, given: %v", conf.Cutter.Cache.CleanJitter)
+	}
+	if conf.Cutter.Cache.Watermark <= 0 || conf.Cutter.Cache.Watermark > 1 {
+		return fmt.Errorf("Cutter.Cache.watermark: must be in (0, 1], given: %v", conf.Cutter.Cache.Watermark)
+	}
+	if conf.Cutter.Cache.Webhook.Queue < 0 {
+		return fmt.Errorf("Cutter.Cache.webhook.queue: must not be negative, given: %v", conf.Cutter.Cache.Webhook.Queue)
+	}
+	if conf.Cutter.Origin.MaxSize <= 0 {
+		return fmt.Errorf("Cutter.Origin.maxsize: must be positive, given: %v", conf.Cutter.Origin.MaxSize)
+	}
+	if conf.Cutter.Origin.Retries < 0 {
+		return fmt.Errorf("Cutter.Origin.retries: must not be negative, given: %v", conf.Cutter.Origin.Retries)
+	}
+	if conf.Cutter.Origin.MaxRedirects < 0 {
+		return fmt.Errorf("Cutter.Origin.maxredirects: must not be negative, given: %v", conf.Cutter.Origin.MaxRedirects)
+	}
+	if conf.Cutter.Origin.RateLimit < 0 || conf.Cutter.Origin.RateBurst < 0 || conf.Cutter.Origin.MaxConcurrent < 0 {
+		return fmt.Errorf("Cutter.Origin: ratelimit, rateburst and maxconcurrent must not be negative")
+	}
+	cropperConfig := conf.Cutter.Cropper
+	if cropperConfig.MaxInputWidth < 0 || cropperConfig.MaxInputHeight < 0 || cropperConfig.MaxMegapixels < 0 ||
+		cropperConfig.MaxWidth < 0 || cropperConfig.MaxHeight < 0 {
+		return fmt.Errorf("Cutter.Cropper: limits must not be negative, given: %+v", cropperConfig)
+	}
+	for i, key := range conf.Cutter.Signing.Keys {
+		if len(key) < 16 {
+			return fmt.Errorf("Cutter.Signing.keys[%v]: must be at least 16 characters long", i)
+		}
+	}
+	if token := conf.Cutter.Admin.Token; token != "" && len(token) < 16 {
+		return fmt.Errorf("Cutter.Admin.token: must be at least 16 characters long")
+	}
+	secrets := make(map[string]string)
+	for name, key := range conf.Cutter.Auth.Keys {
+		if len(key.Key) < 16 {
+			return fmt.Errorf("Cutter.Auth.keys.%v.key: must be at least 16 characters long", name)
+		}
+		if other, ok := secrets[key.Key]; ok {
+			return fmt.Errorf("Cutter.Auth.keys.%v.key: is the same as key of %v", name, other)
+		}
+		secrets[key.Key] = name
+		for _, source := range key.Sources {
+			if _, ok := conf.Cutter.Sources[source]; !ok {
+				return fmt.Errorf("Cutter.Auth.keys.%v.sources: source %v is not configured", name, source)
+			}
+		}
+		for _, operation := range key.Operations {
+			switch operation {
+			case OperationCrop, OperationCache:
+			default:
+				return fmt.Errorf("Cutter.Auth.keys.%v.operations: must be %v or %v, given: %v", name, OperationCrop, OperationCache, operation)
+			}
+		}
+		if key.MaxWidth < 0 || key.MaxHeight < 0 || key.RateLimit < 0 || key.RateBurst < 0 {
+			return fmt.Errorf("Cutter.Auth.keys.%v: maxwidth, maxheight, ratelimit and rateburst must not be negative", name)
+		}
+	}
+	for _, origin := range conf.Cutter.Cors.AllowOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "*.", "", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("Cutter.Cors.alloworigins: must be \"*\" or origin like https://example.com or https://*.example.com, given: %v", origin)
+		}
+	}
+	tracingConfig := conf.Cutter.Tracing
+	switch tracingConfig.Exporter {
+	case "", TracingStdout:
+	case TracingOTLP:
+		u, err := url.Parse(tracingConfig.Endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Cutter.Tracing.endpoint: must be absolute url for otlp exporter, given: %v", tracingConfig.Endpoint)
+		}
+	default:
+		return fmt.Errorf("Cutter.Tracing.exporter: must be empty, %v or %v, given: %v", TracingStdout, TracingOTLP, tracingConfig.Exporter)
+	}
+	if tracingConfig.SampleRatio < 0 || tracingConfig.SampleRatio > 1 {
+		return fmt.Errorf("Cutter.Tracing.sampleratio: must be in [0, 1], given: %v", tracingConfig.SampleRatio)
+	}
+	if tracingConfig.QueueSize < 0 {
+		return fmt.Errorf("Cutter.Tracing.queue: must not be negative, given: %v", tracingConfig.QueueSize)
+	}
+	limits := conf.Cutter.Limits
+	if limits.MaxCrops < 0 || limits.CropQueue < 0 || limits.ClientRate < 0 || limits.ClientBurst < 0 {
+		return fmt.Errorf("Cutter.Limits: maxcrops, cropqueue, clientrate and clientburst must not be negative")
+	}
+	for name, source := range conf.Cutter.Sources {
+		if !sourceName.MatchString(name) {
+			return fmt.Errorf("Cutter.Sources.%v: name must contain only letters, digits, '-' and '_'", name)
+		}
+		switch source.Type {
+		case "", SourceHTTP:
+			u, err := url.Parse(source.Url)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("Cutter.Sources.%v.url: must be absolute url, given: %v", name, source.Url)
+			}
+		case SourceFile:
+			stat, err := os.Stat(source.Root)
+			if err != nil || !stat.IsDir() {
+				return fmt.Errorf("Cutter.Sources.%v.root: must be existing folder, given: %v", name, source.Root)
+			}
+		default:
+			return fmt.Errorf("Cutter.Sources.%v.type: must be %v or %v, given: %v", name, SourceHTTP, SourceFile, source.Type)
+		}
+	}
+	return nil
 }
\ No newline at end of file
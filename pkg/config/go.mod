@@ -2,4 +2,7 @@ module ImageCutter/pkg/config
 
 go 1.12
 
-require github.com/spf13/viper v1.5.0
+require (
+	github.com/mitchellh/mapstructure v1.1.2
+	github.com/spf13/viper v1.5.0
+)
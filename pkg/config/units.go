@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ByteSize is amount of bytes. Accepts values like "512KiB", "2GB", "1.5MiB".
+// Bare numbers are megabytes for compatibility with old configs.
+type ByteSize int64
+
+// Duration accepts values like "90s", "1h30m".
+// Bare numbers are minutes for compatibility with old configs.
+type Duration time.Duration
+
+var sizeUnits = map[string]int64{
+	"b":   1,
+	"k":   1024,
+	"kb":  1000,
+	"kib": 1024,
+	"m":   1024 * 1024,
+	"mb":  1000 * 1000,
+	"mib": 1024 * 1024,
+	"g":   1024 * 1024 * 1024,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1024 * 1024 * 1024,
+	"t":   1024 * 1024 * 1024 * 1024,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1024 * 1024 * 1024 * 1024,
+}
+
+// ParseSize parses size string. Number without unit is megabytes
+func ParseSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	// Split number and unit
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.' && r != '-' && r != '+'
+	})
+	number, unit := s, "mib"
+	if i >= 0 {
+		number, unit = s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("invalid size %q: must not be negative", s)
+	}
+	if value*float64(multiplier) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return ByteSize(value * float64(multiplier)), nil
+}
+
+// ParseDuration parses duration string. Number without unit is minutes
+func ParseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	var d time.Duration
+	if minutes, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.Abs(minutes*float64(time.Minute)) >= math.MaxInt64 || math.IsNaN(minutes) {
+			return 0, fmt.Errorf("invalid duration %q: too large", s)
+		}
+		d = time.Duration(minutes * float64(time.Minute))
+	} else {
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q: must not be negative", s)
+	}
+	return Duration(d), nil
+}
+
+func (b ByteSize) String() string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(b)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64) + units[i]
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Duration returns value as time.Duration
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// unitsHook decodes ByteSize and Duration from yaml strings and numbers
+func unitsHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	switch to {
+	case reflect.TypeOf(ByteSize(0)):
+		return ParseSize(fmt.Sprint(data))
+	case reflect.TypeOf(Duration(0)):
+		return ParseDuration(fmt.Sprint(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    ByteSize
+		wantErr bool
+	}{
+		{size: "512", want: 512 * 1024 * 1024},
+		{size: "0", want: 0},
+		{size: "1.5", want: 1536 * 1024},
+		{size: "100B", want: 100},
+		{size: "512KiB", want: 512 * 1024},
+		{size: "512k", want: 512 * 1024},
+		{size: "512KB", want: 512 * 1000},
+		{size: "1.5MiB", want: 1536 * 1024},
+		{size: "2GB", want: 2 * 1000 * 1000 * 1000},
+		{size: "2gib", want: 2 * 1024 * 1024 * 1024},
+		{size: "1TiB", want: 1024 * 1024 * 1024 * 1024},
+		{size: " 10 MB ", want: 10 * 1000 * 1000},
+		{size: "", wantErr: true},
+		{size: "MB", wantErr: true},
+		{size: "10PB", wantErr: true},
+		{size: "ten", wantErr: true},
+		{size: "1.2.3MB", wantErr: true},
+		{size: "-1KiB", wantErr: true},
+		{size: "-1", wantErr: true},
+		{size: "99999999TiB", wantErr: true},
+		{size: "NaN", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := ParseSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		want     Duration
+		wantErr  bool
+	}{
+		{duration: "10", want: Duration(10 * time.Minute)},
+		{duration: "0", want: 0},
+		{duration: "0.5", want: Duration(30 * time.Second)},
+		{duration: "90s", want: Duration(90 * time.Second)},
+		{duration: "1h30m", want: Duration(90 * time.Minute)},
+		{duration: "250ms", want: Duration(250 * time.Millisecond)},
+		{duration: " 5s ", want: Duration(5 * time.Second)},
+		{duration: "", wantErr: true},
+		{duration: "5 minutes", wantErr: true},
+		{duration: "1d", wantErr: true},
+		{duration: "-5s", wantErr: true},
+		{duration: "-1", wantErr: true},
+		{duration: "1e18", wantErr: true},
+		{duration: "NaN", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.duration, func(t *testing.T) {
+			got, err := ParseDuration(tt.duration)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseDuration() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteSize_String(t *testing.T) {
+	tests := []struct {
+		size ByteSize
+		want string
+	}{
+		{size: 0, want: "0B"},
+		{size: 1023, want: "1023B"},
+		{size: 1536, want: "1.5KiB"},
+		{size: 512 * 1024 * 1024, want: "512MiB"},
+		{size: 2048 * 1024 * 1024 * 1024 * 1024, want: "2048TiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.size.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+			if parsed, err := ParseSize(tt.want); err != nil || parsed != tt.size {
+				t.Errorf("ParseSize(String()) = %v, %v, want %v", parsed, err, tt.size)
+			}
+		})
+	}
+}
+
+func TestUnitsHook(t *testing.T) {
+	tests := []struct {
+		name    string
+		to      reflect.Type
+		data    interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "Size string", to: reflect.TypeOf(ByteSize(0)), data: "2GiB", want: ByteSize(2 * 1024 * 1024 * 1024)},
+		{name: "Size number of old config", to: reflect.TypeOf(ByteSize(0)), data: 512, want: ByteSize(512 * 1024 * 1024)},
+		{name: "Duration string", to: reflect.TypeOf(Duration(0)), data: "90s", want: Duration(90 * time.Second)},
+		{name: "Duration number of old config", to: reflect.TypeOf(Duration(0)), data: 10, want: Duration(10 * time.Minute)},
+		{name: "Invalid size", to: reflect.TypeOf(ByteSize(0)), data: "big", wantErr: true},
+		{name: "Other type", to: reflect.TypeOf(0), data: 512, want: 512},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unitsHook(reflect.TypeOf(tt.data), tt.to, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unitsHook() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("unitsHook() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
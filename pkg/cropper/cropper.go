@@ -2,14 +2,24 @@ package cropper
 
 import (
 	cfg "ImageCutter/pkg/config"
+	logging "ImageCutter/pkg/logger"
 	"ImageCutter/pkg/models"
+	"ImageCutter/pkg/tracing"
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/disintegration/imaging"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp" // webp originals are decoded, cropped images are encoded as jpeg
+	stdimage "image"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"math"
+	"os"
 	"path/filepath"
 )
 
@@ -28,22 +38,156 @@ func NewCropper(logger *zap.Logger, config *cfg.CutterConfig) *Cropper {
 
 
 
-func (c *Cropper) Crop(width int, height int, image *models.Image) ([]byte, error){
+// CheckSize checks requested size of cropped image. Returns http code with error
+func (c *Cropper) CheckSize(width int, height int) (int, error) {
+	limits := c.Config.Cutter.Cropper
+	if width < 0 || height < 0 {
+		return 400, fmt.Errorf("Width and height must not be negative, given: %vx%v", width, height)
+	}
+	if (limits.MaxWidth > 0 && width > limits.MaxWidth) || (limits.MaxHeight > 0 && height > limits.MaxHeight) {
+		return 422, fmt.Errorf("Requested size %vx%v is bigger than allowed %vx%v", width, height, limits.MaxWidth, limits.MaxHeight)
+	}
+	return 200, nil
+}
+
+// checkInput checks original image dimensions read from its header. Returns http code with error
+func (c *Cropper) checkInput(config stdimage.Config) (int, error) {
+	limits := c.Config.Cutter.Cropper
+	if (limits.MaxInputWidth > 0 && config.Width > limits.MaxInputWidth) ||
+		(limits.MaxInputHeight > 0 && config.Height > limits.MaxInputHeight) {
+		return 413, fmt.Errorf("Image size %vx%v is bigger than allowed %vx%v", config.Width, config.Height, limits.MaxInputWidth, limits.MaxInputHeight)
+	}
+	megapixels := float64(config.Width) * float64(config.Height) / 1e6
+	if limits.MaxMegapixels > 0 && megapixels > limits.MaxMegapixels {
+		return 413, fmt.Errorf("Image size %vx%v (%.1f megapixels) is bigger than allowed %v megapixels", config.Width, config.Height, megapixels, limits.MaxMegapixels)
+	}
+	return 200, nil
+}
+
+// outputSize returns size of cropped image. Zero width or height is computed
+// from aspect ratio of original like imaging.Resize does
+func outputSize(config stdimage.Config, width int, height int) (int, int) {
+	if config.Width <= 0 || config.Height <= 0 {
+		return width, height
+	}
+	if width == 0 && height > 0 {
+		width = int(math.Max(1, math.Floor(float64(height)*float64(config.Width)/float64(config.Height)+0.5)))
+	}
+	if height == 0 && width > 0 {
+		height = int(math.Max(1, math.Floor(float64(width)*float64(config.Height)/float64(config.Width)+0.5)))
+	}
+	return width, height
+}
+
+// checkOutput checks size of cropped image computed from original dimensions. Returns http code with error
+func (c *Cropper) checkOutput(config stdimage.Config, width int, height int) (int, error) {
+	outWidth, outHeight := outputSize(config, width, height)
+	if code, err := c.CheckSize(outWidth, outHeight); err != nil {
+		return code, fmt.Errorf("Cropped image of %vx%v original would be %vx%v: %v", config.Width, config.Height, outWidth, outHeight, err)
+	}
+	return 200, nil
+}
+
+// readConfig reads dimensions of image header and checks original and cropped sizes.
+// Returns http code with error
+func (c *Cropper) readConfig(file io.Reader, width int, height int) (stdimage.Config, int, error) {
+	config, _, err := stdimage.DecodeConfig(file)
+	if err != nil {
+		return config, 422, fmt.Errorf("Image header is broken: %v", err)
+	}
+	if code, err := c.checkInput(config); err != nil {
+		return config, code, err
+	}
+	if code, err := c.checkOutput(config, width, height); err != nil {
+		return config, code, err
+	}
+	return config, 200, nil
+}
+
+// Check checks requested size and dimensions of cached image without decoding it,
+// so HEAD requests are answered like crops. Returns size of cropped image and http code with error
+func (c *Cropper) Check(width int, height int, image *models.Image) (int, int, int, error) {
+	if code, err := c.CheckSize(width, height); err != nil {
+		return 0, 0, code, err
+	}
+	file, err := os.Open(filepath.Join(c.Config.Cutter.Cache.Folder, image.Name))
+	if err != nil {
+		return 0, 0, 500, err
+	}
+	defer file.Close()
+	config, code, err := c.readConfig(file, width, height)
+	if err != nil {
+		return 0, 0, code, err
+	}
+	outWidth, outHeight := outputSize(config, width, height)
+	return outWidth, outHeight, 200, nil
+}
+
+// Crop resizes cached image. Original and cropped image dimensions are checked before decoding,
+// so huge images are never loaded in memory. Logs are written with logger of ctx,
+// decoding, resizing and encoding are traced as child spans of cropper.Crop.
+// Returns http code with error
+func (c *Cropper) Crop(ctx context.Context, width int, height int, image *models.Image) ([]byte, int, error){
+	logger := logging.FromContext(ctx, c.Logger)
+	ctx, span := tracing.Start(ctx, "cropper.Crop")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("image.name", image.Name),
+		attribute.Int("crop.width", width),
+		attribute.Int("crop.height", height),
+	)
+	if code, err := c.CheckSize(width, height); err != nil {
+		return nil, code, err
+	}
 
 	imagePath := filepath.Join(c.Config.Cutter.Cache.Folder, image.Name)
 
-	img, err := imaging.Open(imagePath)
+	file, err := os.Open(imagePath)
+	if err != nil {
+		logger.Sugar().Errorf("Cropper cannot open image: %v error: %v", imagePath, err)
+		tracing.SetError(span, err)
+		return nil, 500, err
+	}
+	defer file.Close()
+
+	_, decodeSpan := tracing.Start(ctx, "cropper.Decode")
+	config, code, err := c.readConfig(file, width, height)
+	decodeSpan.SetAttributes(
+		attribute.Int("image.width", config.Width),
+		attribute.Int("image.height", config.Height),
+	)
+	if err != nil {
+		logger.Sugar().Warnf("Cropper rejected image %v: %v", image.Url, err)
+		tracing.SetError(decodeSpan, err)
+		decodeSpan.End()
+		tracing.SetError(span, err)
+		return nil, code, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		logger.Sugar().Errorf("Cropper cannot read image: %v error: %v", imagePath, err)
+		tracing.SetError(decodeSpan, err)
+		decodeSpan.End()
+		tracing.SetError(span, err)
+		return nil, 500, err
+	}
+
+	img, err := imaging.Decode(file)
+	tracing.SetError(decodeSpan, err)
+	decodeSpan.End()
 	if err != nil {
-		c.Logger.Sugar().Errorf("Cropper cannot open image: %v error: %v", imagePath, err)
-		return nil, err
+		logger.Sugar().Errorf("Cropper cannot decode image: %v error: %v", imagePath, err)
+		tracing.SetError(span, err)
+		return nil, 422, err
 	}
 
+	_, resizeSpan := tracing.Start(ctx, "cropper.Resize")
 	resizedImage := imaging.Resize(img, width, height, imaging.Lanczos)
+	resizeSpan.End()
 
+	_, encodeSpan := tracing.Start(ctx, "cropper.Encode")
+	encodeSpan.SetAttributes(attribute.String("image.mime_type", OutputMimeType(image.MimeType)))
 	buffer := new(bytes.Buffer)
-	switch image.MimeType {
-	case "image/jpeg":
-		err = jpeg.Encode(buffer, resizedImage, nil)
+	switch OutputMimeType(image.MimeType) {
 	case "image/png":
 		err = png.Encode(buffer, resizedImage)
 	case "image/tiff":
@@ -53,12 +197,24 @@ func (c *Cropper) Crop(width int, height int, image *models.Image) ([]byte, erro
 	default:
 		err = jpeg.Encode(buffer, resizedImage, nil)
 	}
+	tracing.SetError(encodeSpan, err)
+	encodeSpan.End()
 
 	if err != nil {
-		c.Logger.Sugar().Errorf("Cropper cannot convert image.NRGBA of %v to []byte | Error: %v", image.MimeType, err)
-		return nil, err
+		logger.Sugar().Errorf("Cropper cannot convert image.NRGBA of %v to []byte | Error: %v", image.MimeType, err)
+		tracing.SetError(span, err)
+		return nil, 500, err
 	}
 	croppedImage := buffer.Bytes()
 
-	return croppedImage, nil
+	return croppedImage, 200, nil
+}
+
+// OutputMimeType returns mime type of cropped image. Unsupported formats are encoded as jpeg
+func OutputMimeType(mimeType string) string {
+	switch mimeType {
+	case "image/png", "image/tiff", "image/gif":
+		return mimeType
+	}
+	return "image/jpeg"
 }
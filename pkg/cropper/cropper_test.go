@@ -0,0 +1,131 @@
+package cropper
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"ImageCutter/pkg/models"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"go.uber.org/zap"
+	"hash/crc32"
+	stdimage "image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pngHeader returns PNG signature with IHDR chunk of given size and without pixel data
+func pngHeader(width uint32, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12] = 8 // bit depth
+	ihdr[13] = 6 // RGBA color type
+	buf := bytes.NewBufferString("\x89PNG\r\n\x1a\n")
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(ihdr)-4))
+	buf.Write(ihdr)
+	_ = binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+// gifHeader returns GIF header with logical screen of given size and without image data
+func gifHeader(width uint16, height uint16) []byte {
+	buf := bytes.NewBufferString("GIF89a")
+	_ = binary.Write(buf, binary.LittleEndian, width)
+	_ = binary.Write(buf, binary.LittleEndian, height)
+	buf.Write([]byte{0, 0, 0}) // no global color table
+	return buf.Bytes()
+}
+
+// newTestCropper returns cropper with cache in temp folder and 20000x20000, 50 megapixels input limits
+func newTestCropper(t *testing.T) (*Cropper, func()) {
+	folder, err := ioutil.TempDir("", "cropper")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	config := &cfg.CutterConfig{}
+	config.Cutter.Cache.Folder = folder
+	config.Cutter.Cropper.MaxWidth = 2000
+	config.Cutter.Cropper.MaxHeight = 2000
+	config.Cutter.Cropper.MaxInputWidth = 20000
+	config.Cutter.Cropper.MaxInputHeight = 20000
+	config.Cutter.Cropper.MaxMegapixels = 50
+	return NewCropper(zap.NewNop(), config), func() { _ = os.RemoveAll(folder) }
+}
+
+func TestCropper_Crop_DecompressionBomb(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      []byte
+		mimeType  string
+		wantCode  int
+		wantCheck int // HEAD requests are answered without decoding
+	}{
+		{name: "PNG wider than allowed", file: pngHeader(100000, 10), mimeType: "image/png", wantCode: http.StatusRequestEntityTooLarge, wantCheck: http.StatusRequestEntityTooLarge},
+		{name: "PNG higher than allowed", file: pngHeader(10, 100000), mimeType: "image/png", wantCode: http.StatusRequestEntityTooLarge, wantCheck: http.StatusRequestEntityTooLarge},
+		{name: "PNG with too many pixels", file: pngHeader(10000, 10000), mimeType: "image/png", wantCode: http.StatusRequestEntityTooLarge, wantCheck: http.StatusRequestEntityTooLarge},
+		{name: "PNG with dimensions out of int32", file: pngHeader(0xffffffff, 0xffffffff), mimeType: "image/png", wantCode: http.StatusUnprocessableEntity, wantCheck: http.StatusUnprocessableEntity},
+		{name: "GIF with too many pixels", file: gifHeader(65535, 65535), mimeType: "image/gif", wantCode: http.StatusRequestEntityTooLarge, wantCheck: http.StatusRequestEntityTooLarge},
+		{name: "GIF higher than allowed", file: gifHeader(1, 30000), mimeType: "image/gif", wantCode: http.StatusRequestEntityTooLarge, wantCheck: http.StatusRequestEntityTooLarge},
+		// Header in limits passes checks, so missing pixel data is found only by decoding
+		{name: "PNG header in limits", file: pngHeader(5000, 5000), mimeType: "image/png", wantCode: http.StatusUnprocessableEntity, wantCheck: http.StatusOK},
+		{name: "GIF header in limits", file: gifHeader(5000, 5000), mimeType: "image/gif", wantCode: http.StatusUnprocessableEntity, wantCheck: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, cleanup := newTestCropper(t)
+			defer cleanup()
+			if err := ioutil.WriteFile(filepath.Join(c.Config.Cutter.Cache.Folder, "bomb"), tt.file, 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			image := &models.Image{Name: "bomb", Url: "http://example.com/bomb", MimeType: tt.mimeType}
+
+			cropped, code, err := c.Crop(context.Background(), 100, 100, image)
+			if code != tt.wantCode || err == nil || cropped != nil {
+				t.Errorf("Crop() code = %v, error = %v, want %v", code, err, tt.wantCode)
+			}
+			if _, _, code, err := c.Check(100, 0, image); code != tt.wantCheck {
+				t.Errorf("Check() code = %v, error = %v, want %v", code, err, tt.wantCheck)
+			}
+		})
+	}
+}
+
+func TestCropper_Check(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, stdimage.NewGray(stdimage.Rect(0, 0, 400, 100))); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	tests := []struct {
+		name       string
+		width      int
+		height     int
+		wantWidth  int
+		wantHeight int
+		wantCode   int
+	}{
+		{name: "Both dimensions", width: 200, height: 200, wantWidth: 200, wantHeight: 200, wantCode: http.StatusOK},
+		{name: "Zero height keeps aspect ratio", width: 200, height: 0, wantWidth: 200, wantHeight: 50, wantCode: http.StatusOK},
+		{name: "Zero width keeps aspect ratio", width: 0, height: 10, wantWidth: 40, wantHeight: 10, wantCode: http.StatusOK},
+		{name: "Computed width above limit", width: 0, height: 1000, wantCode: http.StatusUnprocessableEntity},
+		{name: "Requested size above limit", width: 3000, height: 10, wantCode: http.StatusUnprocessableEntity},
+		{name: "Negative size", width: -1, height: 10, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, cleanup := newTestCropper(t)
+			defer cleanup()
+			if err := ioutil.WriteFile(filepath.Join(c.Config.Cutter.Cache.Folder, "wide"), buf.Bytes(), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			width, height, code, err := c.Check(tt.width, tt.height, &models.Image{Name: "wide"})
+			if code != tt.wantCode || width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("Check() = %vx%v, %v (error %v), want %vx%v, %v", width, height, code, err, tt.wantWidth, tt.wantHeight, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package logger
+
+import (
+	"context"
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+// WithLogger returns copy of ctx carrying logger, e.g. logger with request id field
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns logger of ctx or fallback if ctx has no logger
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+			return logger
+		}
+	}
+	return fallback
+}
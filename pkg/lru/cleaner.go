@@ -0,0 +1,101 @@
+package lru
+
+import (
+	"ImageCutter/pkg/models"
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Cleaner periodically shrinks cache to watermark until ctx is done
+func (cc *Cache) Cleaner(ctx context.Context) {
+	defer close(cc.cleanerDone)
+
+	timer := time.NewTimer(cc.nextCleanDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			cc.Logger.Info("Cache cleaner is stopped")
+			return
+		case <-timer.C:
+			cc.Logger.Info("Cache cleaner try remove oldest instances from cache...")
+			_, _ = cc.Clean()
+			timer.Reset(cc.nextCleanDelay())
+		}
+	}
+}
+
+// nextCleanDelay returns clean interval with random jitter
+func (cc *Cache) nextCleanDelay() time.Duration {
+	delay := cc.CleanInterval
+	if cc.CleanJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*cc.CleanJitter))) - cc.CleanJitter
+	}
+	if delay <= 0 {
+		delay = cc.CleanInterval
+	}
+	return delay
+}
+
+// Clean removes least fetched images until cache size is not higher than Watermark * MaxSize.
+// At least one image is kept in cache. Returns number of removed images
+func (cc *Cache) Clean() (int, error) {
+	target := int64(cc.Watermark * float64(cc.MaxSize))
+
+	// Least fetched images go first, older images go first among equal ones
+	cc.lock.RLock()
+	candidates := make([]*models.Image, len(cc.Storage))
+	copy(candidates, cc.Storage)
+	currentSize := cc.CurrentSize
+	cc.lock.RUnlock()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].FetchCount < candidates[j].FetchCount
+	})
+
+	if currentSize <= target {
+		cc.Logger.Sugar().Infof("Cache size %v/%v KB is below watermark %v KB. Nothing to clean", currentSize/1024, cc.MaxSize/1024, target/1024)
+		return 0, nil
+	}
+	cc.Logger.Sugar().Infof("Cache size before clean: %v/%v KB, target: %v KB", currentSize/1024, cc.MaxSize/1024, target/1024)
+
+	removed := 0
+	for _, img := range candidates {
+		cc.lock.Lock()
+		if cc.CurrentSize <= target || len(cc.Storage) <= 1 {
+			cc.lock.Unlock()
+			break
+		}
+		deleted, err := cc.delete(cc.Logger, img)
+		cc.lock.Unlock()
+		if err != nil {
+			cc.Logger.Sugar().Errorf("Deleting cache image give error: %v", err)
+			return removed, err
+		}
+		if deleted {
+			cc.emit(EventExpired, "", "", img)
+			removed += 1
+		}
+	}
+
+	currentSize, _, _ = cc.Usage()
+	cc.Logger.Sugar().Infof("%v images was deleted from cache. Cache size after clean: %v/%v KB", removed, currentSize/1024, cc.MaxSize/1024)
+	return removed, nil
+}
+
+// Close stops cache cleaner, waits until it returns and saves cache index.
+// Index is saved after loading is finished, otherwise it would lose not yet loaded images
+func (cc *Cache) Close() {
+	cc.closeOnce.Do(func() {
+		if cc.cancel == nil {
+			return
+		}
+		cc.cancel()
+		<-cc.cleanerDone
+		<-cc.indexDone
+		if err := cc.SaveIndex(); err != nil {
+			cc.Logger.Sugar().Errorf("Saving cache index give error: %v", err)
+		}
+	})
+}
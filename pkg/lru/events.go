@@ -0,0 +1,63 @@
+package lru
+
+import (
+	"ImageCutter/pkg/models"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventAdded   EventType = "added"   // image was put in cache
+	EventHit     EventType = "hit"     // image was found in cache
+	EventMiss    EventType = "miss"    // image was not found in cache
+	EventEvicted EventType = "evicted" // image was removed to free space for another image
+	EventExpired EventType = "expired" // image was removed by cache cleaner
+	EventDeleted EventType = "deleted" // image was removed by Delete call
+)
+
+// Reasons of EventEvicted
+const (
+	ReasonCapacity = "capacity" // not enough space for incoming image
+	ReasonMissing  = "missing"  // cached image is not found on disk
+)
+
+type Event struct {
+	Type   EventType `json:"type"`
+	Reason string    `json:"reason,omitempty"`
+	Url    string    `json:"url"`
+	Name   string    `json:"name,omitempty"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"`
+}
+
+// Listener receives cache events. It is called synchronously from cache methods without cache lock held,
+// so it may call cache methods which emit no events, e.g. Usage or Contains. It must be fast
+// and must not add, get or delete images, otherwise events are emitted recursively
+type Listener func(event Event)
+
+// Subscribe registers listener for all cache events
+func (cc *Cache) Subscribe(listener Listener) {
+	cc.listenersLock.Lock()
+	cc.listeners = append(cc.listeners, listener)
+	cc.listenersLock.Unlock()
+}
+
+func (cc *Cache) emit(eventType EventType, reason string, url string, image *models.Image) {
+	cc.listenersLock.RLock()
+	listeners := cc.listeners
+	cc.listenersLock.RUnlock()
+	if len(listeners) == 0 {
+		return
+	}
+
+	event := Event{Type: eventType, Reason: reason, Url: url, Time: time.Now()}
+	if image != nil {
+		event.Url = image.Url
+		event.Name = image.Name
+		event.Size = image.Size
+	}
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
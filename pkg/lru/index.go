@@ -0,0 +1,106 @@
+package lru
+
+import (
+	"ImageCutter/pkg/models"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Cache index is kept in cache folder between restarts
+const (
+	indexFileName = "index.json"
+	indexVersion  = 1
+)
+
+type cacheIndex struct {
+	Version int             `json:"version"`
+	Images  []*models.Image `json:"images"`
+}
+
+// SaveIndex writes cached images to index file in cache folder. File is replaced atomically,
+// so interrupted save keeps previous index
+func (cc *Cache) SaveIndex() error {
+	cc.lock.RLock()
+	index := cacheIndex{Version: indexVersion, Images: make([]*models.Image, len(cc.Storage))}
+	copy(index.Images, cc.Storage)
+	body, err := json.Marshal(index)
+	cc.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(cc.Folder, "index-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tempFile.Close()
+		if _, err := os.Stat(tempFile.Name()); err == nil {
+			_ = os.Remove(tempFile.Name())
+		}
+	}()
+	if _, err := tempFile.Write(body); err != nil {
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile.Name(), filepath.Join(cc.Folder, indexFileName)); err != nil {
+		return err
+	}
+	cc.Logger.Sugar().Infof("Cache index with %v images was saved", len(index.Images))
+	return nil
+}
+
+// removeTempFiles removes temp files left by interrupted downloads
+func (cc *Cache) removeTempFiles() {
+	tempFiles, _ := filepath.Glob(filepath.Join(cc.Folder, "*.tmp"))
+	for _, tempFile := range tempFiles {
+		cc.Logger.Sugar().Infof("Removing unfinished file %v", tempFile)
+		_ = os.Remove(tempFile)
+	}
+}
+
+// LoadIndex adds images from index file of cache folder and returns their number.
+// Images without files on disk and urls which are already cached are skipped
+func (cc *Cache) LoadIndex() (int, error) {
+	body, err := ioutil.ReadFile(filepath.Join(cc.Folder, indexFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	index := cacheIndex{}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return 0, fmt.Errorf("decoding cache index give error: %v", err)
+	}
+	if index.Version != indexVersion {
+		return 0, fmt.Errorf("cache index version %v is not supported", index.Version)
+	}
+
+	loaded := 0
+	for _, img := range index.Images {
+		// Image could be fetched by request while index was loading
+		if cc.find(img.Url) != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(cc.Folder, img.Name)); err != nil {
+			cc.Logger.Sugar().Warnf("Image %v of cache index is not found on disk and is skipped", img.Url)
+			continue
+		}
+		if err := cc.Add(img); err != nil {
+			cc.Logger.Sugar().Warnf("Image %v of cache index is skipped: %v", img.Url, err)
+			continue
+		}
+		loaded += 1
+	}
+	cc.Logger.Sugar().Infof("Cache index was loaded: %v of %v images", loaded, len(index.Images))
+	return loaded, nil
+}
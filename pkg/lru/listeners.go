@@ -0,0 +1,144 @@
+package lru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogListener writes every cache event to logger with debug level
+func LogListener(logger *zap.Logger) Listener {
+	return func(event Event) {
+		if event.Reason != "" {
+			logger.Sugar().Debugf("Cache event %v (%v): %v [%v] %v bytes", event.Type, event.Reason, event.Url, event.Name, event.Size)
+			return
+		}
+		logger.Sugar().Debugf("Cache event %v: %v [%v] %v bytes", event.Type, event.Url, event.Name, event.Size)
+	}
+}
+
+// Stats counts cache events. Use Stats.Listen as cache listener
+type Stats struct {
+	Added        int64 `json:"added"`
+	AddedBytes   int64 `json:"added_bytes"`
+	Hits         int64 `json:"hits"`
+	Misses       int64 `json:"misses"`
+	Evicted      int64 `json:"evicted"`
+	EvictedBytes int64 `json:"evicted_bytes"`
+	Expired      int64 `json:"expired"`
+	ExpiredBytes int64 `json:"expired_bytes"`
+	Deleted      int64 `json:"deleted"`
+	DeletedBytes int64 `json:"deleted_bytes"`
+}
+
+func (s *Stats) Listen(event Event) {
+	switch event.Type {
+	case EventAdded:
+		atomic.AddInt64(&s.Added, 1)
+		atomic.AddInt64(&s.AddedBytes, event.Size)
+	case EventHit:
+		atomic.AddInt64(&s.Hits, 1)
+	case EventMiss:
+		atomic.AddInt64(&s.Misses, 1)
+	case EventEvicted:
+		atomic.AddInt64(&s.Evicted, 1)
+		atomic.AddInt64(&s.EvictedBytes, event.Size)
+	case EventExpired:
+		atomic.AddInt64(&s.Expired, 1)
+		atomic.AddInt64(&s.ExpiredBytes, event.Size)
+	case EventDeleted:
+		atomic.AddInt64(&s.Deleted, 1)
+		atomic.AddInt64(&s.DeletedBytes, event.Size)
+	}
+}
+
+// Snapshot returns consistent copy of counters
+func (s *Stats) Snapshot() Stats {
+	return Stats{
+		Added:        atomic.LoadInt64(&s.Added),
+		AddedBytes:   atomic.LoadInt64(&s.AddedBytes),
+		Hits:         atomic.LoadInt64(&s.Hits),
+		Misses:       atomic.LoadInt64(&s.Misses),
+		Evicted:      atomic.LoadInt64(&s.Evicted),
+		EvictedBytes: atomic.LoadInt64(&s.EvictedBytes),
+		Expired:      atomic.LoadInt64(&s.Expired),
+		ExpiredBytes: atomic.LoadInt64(&s.ExpiredBytes),
+		Deleted:      atomic.LoadInt64(&s.Deleted),
+		DeletedBytes: atomic.LoadInt64(&s.DeletedBytes),
+	}
+}
+
+// Webhook posts cache events as JSON to remote url.
+// Events are sent from background goroutine, so slow receiver does not block the cache.
+// If queue is full new events are dropped
+type Webhook struct {
+	Url    string
+	Client *http.Client
+	Logger *zap.Logger
+	queue  chan Event
+	closed bool
+	lock   sync.RWMutex
+}
+
+func NewWebhook(logger *zap.Logger, url string, timeout time.Duration, queueSize int) *Webhook {
+	wh := &Webhook{
+		Url:    url,
+		Client: &http.Client{Timeout: timeout},
+		Logger: logger,
+		queue:  make(chan Event, queueSize),
+	}
+	go wh.sender()
+	return wh
+}
+
+func (wh *Webhook) Listen(event Event) {
+	wh.lock.RLock()
+	defer wh.lock.RUnlock()
+	if wh.closed {
+		return
+	}
+	select {
+	case wh.queue <- event:
+	default:
+		wh.Logger.Sugar().Warnf("Cache webhook queue is full. Event %v for %v is dropped", event.Type, event.Url)
+	}
+}
+
+// Close stops sender goroutine after all queued events are sent
+func (wh *Webhook) Close() {
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+	if !wh.closed {
+		wh.closed = true
+		close(wh.queue)
+	}
+}
+
+func (wh *Webhook) sender() {
+	for event := range wh.queue {
+		if err := wh.send(event); err != nil {
+			wh.Logger.Sugar().Warnf("Sending cache event %v to webhook %v give error: %v", event.Type, wh.Url, err)
+		}
+	}
+}
+
+func (wh *Webhook) send(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := wh.Client.Post(wh.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook return %v code", resp.StatusCode)
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package lru
 
 import (
+	logging "ImageCutter/pkg/logger"
 	"ImageCutter/pkg/models"
+	"ImageCutter/pkg/tracing"
+	"context"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"os"
 	"path/filepath"
@@ -14,14 +18,26 @@ import (
 type Cache struct {
 	CurrentSize int64
 	MaxSize int64
-	CleanInterval int
+	CleanInterval time.Duration
+	CleanJitter time.Duration // cleaner interval is randomly changed on +-CleanJitter
+	Watermark float64 // cleaner shrinks cache to Watermark * MaxSize
 	Folder string
 	Storage []*models.Image
 	Logger *zap.Logger
 	lock *sync.RWMutex
+	refs map[string]int // number of urls referencing each image file
+	listeners []Listener
+	listenersLock sync.RWMutex
+	cancel context.CancelFunc
+	cleanerDone chan struct{}
+	closeOnce sync.Once
+	indexDone chan struct{} // closed when cache index is loaded
 }
 
-func NewCache (logger *zap.Logger, size int64, folder string, cleanInterval int) (*Cache, error) {
+// NewCache creates cache with maximum size in bytes, starts loading cache index of folder and starts cache cleaner.
+// Cleaner runs every cleanInterval +- cleanJitter and shrinks cache to watermark * size.
+// Cleaner stops when ctx is done or Close is called
+func NewCache (ctx context.Context, logger *zap.Logger, size int64, folder string, cleanInterval time.Duration, cleanJitter time.Duration, watermark float64) (*Cache, error) {
 
 	if _, err := os.Stat(folder); os.IsNotExist(err) {
 		err = os.MkdirAll(folder, os.ModePerm)
@@ -35,104 +51,262 @@ func NewCache (logger *zap.Logger, size int64, folder string, cleanInterval int)
 		logger.Sugar().Infof("Cache folder: '%v' is exist", folder)
 	}
 	cache := &Cache{
-		MaxSize: size * 1024 * 1024,
+		MaxSize: size,
 		Folder: folder,
 		CleanInterval: cleanInterval,
+		CleanJitter: cleanJitter,
+		Watermark: watermark,
 		Storage: make([]*models.Image, 0),
 		Logger: logger,
 		lock: &sync.RWMutex{},
+		cleanerDone: make(chan struct{}),
 	}
+	// Index is loaded in background, so big index does not delay start. Broken index is not fatal, cache starts empty.
+	// Temp files are removed before, otherwise files of new downloads could be removed
+	cache.removeTempFiles()
+	cache.indexDone = make(chan struct{})
+	go func() {
+		defer close(cache.indexDone)
+		if _, err := cache.LoadIndex(); err != nil {
+			logger.Sugar().Warnf("Loading cache index give error: %v", err)
+		}
+	}()
+	ctx, cache.cancel = context.WithCancel(ctx)
 	logger.Info("Start cache cleaner goroutine")
-	go cache.Cleaner() // Cache cleaner
+	go cache.Cleaner(ctx) // Cache cleaner
 
 	return cache, nil
 }
 
 
 func (cc *Cache) Add(img *models.Image) error{
+	return cc.AddContext(context.Background(), img)
+}
+
+// AddContext is Add which writes logs with logger of ctx.
+// If img.TempFile is set, it is moved to image file under cache lock together with adding reference,
+// so concurrent eviction of same image file for another url cannot remove it.
+// Temp file is moved even if image is not cached, so caller can still use image file
+func (cc *Cache) AddContext(ctx context.Context, img *models.Image) error{
+	logger := logging.FromContext(ctx, cc.Logger)
+	_, span := tracing.Start(ctx, "lru.Add")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("image.name", img.Name),
+		attribute.Int64("image.size", img.Size),
+	)
+	defer func() {
+		if img.TempFile == "" {
+			return
+		}
+		cc.lock.Lock()
+		err := cc.moveTempFile(img)
+		cc.lock.Unlock()
+		if err != nil {
+			logger.Sugar().Errorf("Moving image file %v give error: %v", img.Name, err)
+		}
+	}()
 	// if image size too big - not put it in cache
 	if img.Size > cc.MaxSize {
 		mess := fmt.Sprintf("Image size is higher than maximum cache size! %v Kb vs %v Kb. This image will not be caching!", img.Size / 1024, cc.MaxSize / 1024)
-		cc.Logger.Info(mess)
+		logger.Info(mess)
+		tracing.SetError(span, errors.New(mess))
 		return errors.New(mess)
 	}
 
-	// will remove cache images until get enough cache space for incoming image
+	// Image file can be already cached for another url. In this case it takes no extra space
+	cc.lock.RLock()
+	needed := img.Size
+	if cc.refs[img.Name] > 0 {
+		needed = 0
+	}
+	cc.lock.RUnlock()
+
+	// will remove cache images until get enough cache space for incoming image.
+	// Cache state is read under lock, events are emitted without lock, so listeners may read cache
 	tries := 1
-	for cc.CurrentSize + img.Size > cc.MaxSize {
+	for {
+		cc.lock.Lock()
+		if cc.CurrentSize + needed <= cc.MaxSize || len(cc.Storage) == 0 {
+			cc.lock.Unlock()
+			break
+		}
 		if len(cc.Storage) == 1{
-			cc.lock.Lock() // Lock for safety
-			err := cc.Delete(cc.Storage[0])
+			evicted := cc.Storage[0]
+			_, err := cc.delete(logger, evicted)
 			cc.lock.Unlock()
 			if err != nil {
-				cc.Logger.Sugar().Errorf("Deleting cache image give error: %v", err)
+				logger.Sugar().Errorf("Deleting cache image give error: %v", err)
+				tracing.SetError(span, err)
 				return err
 			}
+			cc.emit(EventEvicted, ReasonCapacity, "", evicted)
 			break
 		}
-		cc.Logger.Sugar().Infof("Free cache space is not enough for incoming image with size: %v Kb. Try remove oldest images from cache (%v try)", img.Size / 1024, tries)
-		err := cc.RemoveOldest()
+		cc.lock.Unlock()
+		logger.Sugar().Infof("Free cache space is not enough for incoming image with size: %v Kb. Try remove oldest images from cache (%v try)", img.Size / 1024, tries)
+		err := cc.removeOldest(logger, EventEvicted, ReasonCapacity)
 		if err != nil {
-			cc.Logger.Sugar().Errorf("RemoveOldest give error: %v", err)
+			logger.Sugar().Errorf("RemoveOldest give error: %v", err)
+			tracing.SetError(span, err)
 			return err
 		}
 		tries += 1
 	}
 	cc.lock.Lock()
+	if err := cc.moveTempFile(img); err != nil {
+		cc.lock.Unlock()
+		logger.Sugar().Errorf("Moving image file %v give error: %v", img.Name, err)
+		tracing.SetError(span, err)
+		return err
+	}
+	if cc.refs == nil {
+		cc.refs = make(map[string]int)
+	}
+	if cc.refs[img.Name] == 0 {
+		cc.CurrentSize += img.Size
+		logger.Sugar().Infof("Cache size increased from %v/%v KB to %v/%v KB", cc.CurrentSize-img.Size, cc.MaxSize, cc.CurrentSize, cc.MaxSize)
+	} else {
+		logger.Sugar().Infof("Image file %v is already cached for another url. Cache size is not changed", img.Name)
+	}
+	cc.refs[img.Name] += 1
 	cc.Storage = append(cc.Storage, img)
-	cc.CurrentSize += img.Size
-	cc.Logger.Sugar().Infof("Cache size increased from %v/%v KB to %v/%v KB", cc.CurrentSize-img.Size, cc.MaxSize, cc.CurrentSize, cc.MaxSize)
 	cc.lock.Unlock()
+	cc.emit(EventAdded, "", "", img)
 
 	return nil
 }
 
+// moveTempFile moves temp file of image to image file. Same name means same content,
+// so existing file is safely replaced. Cache lock must be held
+func (cc *Cache) moveTempFile(img *models.Image) error {
+	if img.TempFile == "" {
+		return nil
+	}
+	if err := os.Rename(img.TempFile, filepath.Join(cc.Folder, img.Name)); err != nil {
+		return err
+	}
+	img.TempFile = ""
+	return nil
+}
+
 func (cc *Cache) Delete(image *models.Image) error{
+	cc.lock.Lock()
+	removed, err := cc.delete(cc.Logger, image)
+	cc.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if removed {
+		cc.emit(EventDeleted, "", "", image)
+	}
+	return nil
+}
+
+// delete removes image from storage without emitting events. Cache lock must be held.
+// Image file is removed from disk only when no other url references it.
+// Returns true if image was in cache storage
+func (cc *Cache) delete(logger *zap.Logger, image *models.Image) (bool, error){
 	imagePath := filepath.Join(cc.Folder, image.Name)
 
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-		cc.Logger.Sugar().Errorf("Image %v is not found on disk!", imagePath)
+	ind, err := cc.GetImageIndex(image)
+	inStorage := err == nil
+	refs := cc.refs[image.Name]
+	if inStorage {
+		refs -= 1
+	}
+
+	if refs > 0 {
+		logger.Sugar().Infof("Image %v is still used by %v urls and will be kept on disk", imagePath, refs)
+	} else if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+		logger.Sugar().Errorf("Image %v is not found on disk!", imagePath)
 	} else {
 		err := os.Remove(imagePath)
 		if err != nil{
-			cc.Logger.Sugar().Errorf("Removing image: %v from disk give error: %v", imagePath, err)
-			return err
+			logger.Sugar().Errorf("Removing image: %v from disk give error: %v", imagePath, err)
+			return false, err
 		}
 	}
-	ind, err := cc.GetImageIndex(image)
-	if err != nil {
-		cc.Logger.Sugar().Errorf("Image %v is not found in cache storage!", imagePath)
+	if !inStorage {
+		logger.Sugar().Errorf("Image %v is not found in cache storage!", imagePath)
+		return false, nil
+	}
+
+	// Delete elem from slice
+	if ind < len(cc.Storage) - 1 {
+		copy(cc.Storage[ind:], cc.Storage[ind+1:])
+	}
+	cc.Storage[len(cc.Storage)-1] = nil
+	cc.Storage = cc.Storage[:len(cc.Storage)-1]
+	if refs > 0 {
+		cc.refs[image.Name] = refs
 	} else {
-		// Delete elem from slice
-		if ind < len(cc.Storage) - 1 {
-			copy(cc.Storage[ind:], cc.Storage[ind+1:])
-		}
-		cc.Storage[len(cc.Storage)-1] = nil
-		cc.Storage = cc.Storage[:len(cc.Storage)-1]
+		delete(cc.refs, image.Name)
 		cc.CurrentSize -= image.Size // Decrease current cache size
 	}
 
-	return nil
+	return true, nil
 }
 
 
 func (cc *Cache) GetImageByUrl(url string) (*models.Image, error) {
-	for _, img := range cc.Storage{
-		if img.Url == url {
+	return cc.GetImageByUrlContext(context.Background(), url)
+}
 
-			imagePath := filepath.Join(cc.Folder, img.Name)
-			if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-				mess := fmt.Sprintf("Already cached image %v is not found on disk!", img.Url)
-				cc.Logger.Warn(mess)
-				return nil, errors.New(mess)
-			}
-			return img, nil
+// GetImageByUrlContext is GetImageByUrl which writes logs with logger of ctx
+func (cc *Cache) GetImageByUrlContext(ctx context.Context, url string) (*models.Image, error) {
+	logger := logging.FromContext(ctx, cc.Logger)
+	_, span := tracing.Start(ctx, "lru.GetImageByUrl")
+	defer span.End()
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+	image := cc.find(url)
+	if image == nil {
+		mess := fmt.Sprintf("Image with url: %v not in cache", url)
+		logger.Info(mess)
+		cc.emit(EventMiss, "", url, nil)
+		return nil, errors.New(mess)
+	}
+
+	imagePath := filepath.Join(cc.Folder, image.Name)
+	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+		mess := fmt.Sprintf("Already cached image %v is not found on disk!", image.Url)
+		logger.Warn(mess)
+		// Drop stale record so image can be cached again
+		cc.lock.Lock()
+		removed, err := cc.delete(logger, image)
+		cc.lock.Unlock()
+		if err == nil && removed {
+			cc.emit(EventEvicted, ReasonMissing, "", image)
 		}
+		cc.emit(EventMiss, "", url, nil)
+		return nil, errors.New(mess)
 	}
-	mess := fmt.Sprintf("Image with url: %v not in cache", url)
-	cc.Logger.Info(mess)
+	cc.emit(EventHit, "", "", image)
+	span.SetAttributes(attribute.Bool("cache.hit", true))
+	return image, nil
+}
 
-	return nil, errors.New(mess)
+// Contains reports whether image of url is cached and its file exists. Unlike GetImageByUrl
+// it does not emit hit and miss events, so existence checks do not change cache statistics
+func (cc *Cache) Contains(url string) bool {
+	image := cc.find(url)
+	if image == nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(cc.Folder, image.Name))
+	return err == nil
+}
+
+// find returns cached image of url or nil. Hit and miss events are not emitted
+func (cc *Cache) find(url string) *models.Image {
+	cc.lock.RLock()
+	defer cc.lock.RUnlock()
+	for _, img := range cc.Storage {
+		if img.Url == url {
+			return img
+		}
+	}
+	return nil
 }
 
 func (cc *Cache) GetImageIndex(image *models.Image) (int, error) {
@@ -144,35 +318,47 @@ func (cc *Cache) GetImageIndex(image *models.Image) (int, error) {
 	return -1, fmt.Errorf("Image with name: %v not found in cache storage", image.Name)
 }
 
+// RemoveOldest removes least fetched images from cache
 func (cc *Cache) RemoveOldest() error{
-	if len(cc.Storage) <= 1{
-		if len(cc.Storage) == 0{
-			cc.Logger.Sugar().Infof("Cache is empty!")
+	return cc.removeOldest(cc.Logger, EventExpired, "")
+}
+
+// removeOldest removes least fetched images and emits eventType with reason for each of them
+func (cc *Cache) removeOldest(logger *zap.Logger, eventType EventType, reason string) error{
+	// Images are chosen from copy of storage, so listeners are called without lock
+	cc.lock.RLock()
+	storage := make([]*models.Image, len(cc.Storage))
+	copy(storage, cc.Storage)
+	currentSize := cc.CurrentSize
+	cc.lock.RUnlock()
+	if len(storage) <= 1{
+		if len(storage) == 0{
+			logger.Sugar().Infof("Cache is empty!")
 		}
-		if len(cc.Storage) == 1{
-			cc.Logger.Sugar().Infof("Only 1 image in cache. Cache should keep at least 1 image")
+		if len(storage) == 1{
+			logger.Sugar().Infof("Only 1 image in cache. Cache should keep at least 1 image")
 		}
 		return nil
 	}
-	cc.Logger.Sugar().Infof("Cache size before clean: %v/%v KB", cc.CurrentSize / 1024, cc.MaxSize / 1024)
+	logger.Sugar().Infof("Cache size before clean: %v/%v KB", currentSize / 1024, cc.MaxSize / 1024)
 
-	minFetch := cc.Storage[0].FetchCount
+	minFetch := storage[0].FetchCount
 
-	for _, img := range cc.Storage{
+	for _, img := range storage{
 		if img.FetchCount < minFetch {
 			minFetch = img.FetchCount
 		}
 	}
 	deletedImages := make([]*models.Image, 0)
-	for _, img := range cc.Storage{
+	for _, img := range storage{
 		if img.FetchCount == minFetch {
 			deletedImages = append(deletedImages, img)
 		}
 	}
 	// If all elems have equal FetchCount -> we should keep at least one elem in cache
 	leftOne := false
-	if len(deletedImages) == len(cc.Storage){
-		cc.Logger.Sugar().Infof("%v images have equal FetchCount -> At least 1 image will be kept in cache", len(deletedImages))
+	if len(deletedImages) == len(storage){
+		logger.Sugar().Infof("%v images have equal FetchCount -> At least 1 image will be kept in cache", len(deletedImages))
 		leftOne = true
 	}
 	deleted := 0
@@ -181,27 +367,38 @@ func (cc *Cache) RemoveOldest() error{
 			leftOne = false
 			continue
 		}
-		cc.lock.Lock() // Lock for safety
-		err := cc.Delete(img)
+		cc.lock.Lock()
+		removed, err := cc.delete(logger, img)
 		cc.lock.Unlock()
 		if err != nil {
-			cc.Logger.Sugar().Errorf("Deleting cache image give error: %v", err)
+			logger.Sugar().Errorf("Deleting cache image give error: %v", err)
 			return err
 		}
-		deleted += 1
+		if removed {
+			cc.emit(eventType, reason, "", img)
+			deleted += 1
+		}
 	}
-	cc.Logger.Sugar().Infof("%v oldest images was deleted from cache", deleted)
-	cc.Logger.Sugar().Infof("Cache size after clean: %v/%v KB", cc.CurrentSize / 1024, cc.MaxSize / 1024)
+	currentSize, _, _ = cc.Usage()
+	logger.Sugar().Infof("%v oldest images was deleted from cache", deleted)
+	logger.Sugar().Infof("Cache size after clean: %v/%v KB", currentSize / 1024, cc.MaxSize / 1024)
 	return nil
 
 }
 
+// Usage returns current size, maximum size and number of cached images
+func (cc *Cache) Usage() (int64, int64, int) {
+	cc.lock.RLock()
+	defer cc.lock.RUnlock()
+	return cc.CurrentSize, cc.MaxSize, len(cc.Storage)
+}
 
-func (cc *Cache) Cleaner() {
-	sleepTime := time.Minute * time.Duration(cc.CleanInterval)
-	for {
-		cc.Logger.Info("Cache cleaner try remove oldest instances from cache...")
-		_ = cc.RemoveOldest()
-		time.Sleep(sleepTime)
+// IndexLoaded reports whether loading of cache index is finished. Broken index is discarded
+func (cc *Cache) IndexLoaded() bool {
+	select {
+	case <-cc.indexDone:
+		return true
+	default:
+		return false
 	}
-}
\ No newline at end of file
+}
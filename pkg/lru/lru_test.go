@@ -2,12 +2,19 @@ package lru
 
 import (
 	"ImageCutter/pkg/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
 	logging "ImageCutter/pkg/logger"
 	cfg "ImageCutter/pkg/config"
 	"os"
 	"path"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestCache_Add(t *testing.T) {
@@ -25,23 +32,17 @@ func TestCache_Add(t *testing.T) {
 	}
 
 	// Creating temp folder for cache
-	cacheFolder := "test_images"
-	if _, err := os.Stat(cacheFolder); os.IsExist(err) {
-		err = os.RemoveAll(cacheFolder)
-		if err != nil {
-			t.Errorf("Add() Cannot delete cache folder at %v\n", cacheFolder)
-		}
-	}
-	err = os.MkdirAll(cacheFolder, os.ModePerm)
+	cacheFolder, err := ioutil.TempDir("", "lru")
 	if err != nil {
-		t.Errorf("Add() Cannot create cache folder at %v\n", cacheFolder)
+		t.Fatalf("Add() Cannot create cache folder: %v", err)
 	}
+	defer os.RemoveAll(cacheFolder)
 
 
 	// Emtpty cache for first test
 	emptyCache := &Cache{
 		MaxSize:       10 * 1024 * 1024,
-		CleanInterval: 5,
+		CleanInterval: 5 * time.Minute,
 		Folder:        cacheFolder,
 		Storage:       make([]*models.Image, 0),
 		Logger:        logger,
@@ -53,7 +54,7 @@ func TestCache_Add(t *testing.T) {
 	// Full cache for second test
 	fullCache := &Cache{
 		MaxSize:       2 * 1024 * 1024,
-		CleanInterval: 5,
+		CleanInterval: 5 * time.Minute,
 		Folder:        cacheFolder,
 		Storage:       make([]*models.Image, 0),
 		Logger:        logger,
@@ -132,23 +133,17 @@ func TestCache_Delete(t *testing.T) {
 	}
 
 	// Creating temp folder for cache
-	cacheFolder := "test_images"
-	if _, err := os.Stat(cacheFolder); os.IsExist(err) {
-		err = os.RemoveAll(cacheFolder)
-		if err != nil {
-			t.Errorf("Add() Cannot delete cache folder at %v\n", cacheFolder)
-		}
-	}
-	err = os.MkdirAll(cacheFolder, os.ModePerm)
+	cacheFolder, err := ioutil.TempDir("", "lru")
 	if err != nil {
-		t.Errorf("Add() Cannot create cache folder at %v\n", cacheFolder)
+		t.Fatalf("Add() Cannot create cache folder: %v", err)
 	}
+	defer os.RemoveAll(cacheFolder)
 
 
 	// Emtpty cache
 	emptyCache := &Cache{
 		MaxSize:       10 * 1024 * 1024,
-		CleanInterval: 5,
+		CleanInterval: 5 * time.Minute,
 		Folder:        cacheFolder,
 		Storage:       make([]*models.Image, 0),
 		Logger:        logger,
@@ -256,23 +251,17 @@ func TestCache_GetImageByUrl(t *testing.T) {
 	}
 
 	// Creating temp folder for cache
-	cacheFolder := "test_images"
-	if _, err := os.Stat(cacheFolder); os.IsExist(err) {
-		err = os.RemoveAll(cacheFolder)
-		if err != nil {
-			t.Errorf("Add() Cannot delete cache folder at %v\n", cacheFolder)
-		}
-	}
-	err = os.MkdirAll(cacheFolder, os.ModePerm)
+	cacheFolder, err := ioutil.TempDir("", "lru")
 	if err != nil {
-		t.Errorf("Add() Cannot create cache folder at %v\n", cacheFolder)
+		t.Fatalf("Add() Cannot create cache folder: %v", err)
 	}
+	defer os.RemoveAll(cacheFolder)
 
 
 	// Emtpty cache
 	emptyCache := &Cache{
 		MaxSize:       10 * 1024 * 1024,
-		CleanInterval: 5,
+		CleanInterval: 5 * time.Minute,
 		Folder:        cacheFolder,
 		Storage:       make([]*models.Image, 0),
 		Logger:        logger,
@@ -363,23 +352,17 @@ func TestCache_GetImageIndex(t *testing.T) {
 	}
 
 	// Creating temp folder for cache
-	cacheFolder := "test_images"
-	if _, err := os.Stat(cacheFolder); os.IsExist(err) {
-		err = os.RemoveAll(cacheFolder)
-		if err != nil {
-			t.Errorf("Add() Cannot delete cache folder at %v\n", cacheFolder)
-		}
-	}
-	err = os.MkdirAll(cacheFolder, os.ModePerm)
+	cacheFolder, err := ioutil.TempDir("", "lru")
 	if err != nil {
-		t.Errorf("Add() Cannot create cache folder at %v\n", cacheFolder)
+		t.Fatalf("Add() Cannot create cache folder: %v", err)
 	}
+	defer os.RemoveAll(cacheFolder)
 
 
 	// Emtpty cache
 	emptyCache := &Cache{
 		MaxSize:       10 * 1024 * 1024,
-		CleanInterval: 5,
+		CleanInterval: 5 * time.Minute,
 		Folder:        cacheFolder,
 		Storage:       make([]*models.Image, 0),
 		Logger:        logger,
@@ -452,23 +435,17 @@ func TestCache_RemoveOldest(t *testing.T) {
 	}
 
 	// Creating temp folder for cache
-	cacheFolder := "test_images"
-	if _, err := os.Stat(cacheFolder); os.IsExist(err) {
-		err = os.RemoveAll(cacheFolder)
-		if err != nil {
-			t.Errorf("Add() Cannot delete cache folder at %v\n", cacheFolder)
-		}
-	}
-	err = os.MkdirAll(cacheFolder, os.ModePerm)
+	cacheFolder, err := ioutil.TempDir("", "lru")
 	if err != nil {
-		t.Errorf("Add() Cannot create cache folder at %v\n", cacheFolder)
+		t.Fatalf("Add() Cannot create cache folder: %v", err)
 	}
+	defer os.RemoveAll(cacheFolder)
 
 
 	// Emtpty cache
 	emptyCache := &Cache{
 		MaxSize:       10 * 1024 * 1024,
-		CleanInterval: 5,
+		CleanInterval: 5 * time.Minute,
 		Folder:        cacheFolder,
 		Storage:       make([]*models.Image, 0),
 		Logger:        logger,
@@ -480,7 +457,7 @@ func TestCache_RemoveOldest(t *testing.T) {
 	// Emtpty cache
 	oneElemCache := &Cache{
 		MaxSize:       10 * 1024 * 1024,
-		CleanInterval: 5,
+		CleanInterval: 5 * time.Minute,
 		Folder:        cacheFolder,
 		Storage:       make([]*models.Image, 0),
 		Logger:        logger,
@@ -492,7 +469,7 @@ func TestCache_RemoveOldest(t *testing.T) {
 	// Emtpty cache
 	twoElemCache := &Cache{
 		MaxSize:       10 * 1024 * 1024,
-		CleanInterval: 5,
+		CleanInterval: 5 * time.Minute,
 		Folder:        cacheFolder,
 		Storage:       make([]*models.Image, 0),
 		Logger:        logger,
@@ -557,4 +534,559 @@ func TestCache_RemoveOldest(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestCache_Subscribe(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("Subscribe() create logger give error: %v", err)
+	}
+
+	// Creating temp folder for cache
+	cacheFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("Subscribe() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(cacheFolder)
+
+	cache := &Cache{
+		MaxSize:       2 * 1024 * 1024,
+		CleanInterval: 5 * time.Minute,
+		Folder:        cacheFolder,
+		Storage:       make([]*models.Image, 0),
+		Logger:        logger,
+		lock:          &sync.RWMutex{},
+	}
+	events := make([]EventType, 0)
+	cache.Subscribe(func(event Event) {
+		events = append(events, event.Type)
+	})
+	stats := &Stats{}
+	cache.Subscribe(stats.Listen)
+
+	firstImage := &models.Image{Name: "eventsFirst.jpg", MimeType: "image/jpeg", Url: "url_events_first", Size: 1.5 * 1024 * 1024}
+	secondImage := &models.Image{Name: "eventsSecond.jpg", MimeType: "image/jpeg", Url: "url_events_second", Size: 1 * 1024 * 1024}
+	firstImageFile, err := os.Create(path.Join(cacheFolder, firstImage.Name))
+	if err != nil{
+		t.Errorf("Subscribe() Cannot create firstImageFile:%v", err)
+	}
+	firstImageFile.Close()
+
+	_ = cache.Add(firstImage)                    // added
+	_, _ = cache.GetImageByUrl(firstImage.Url)   // hit
+	_, _ = cache.GetImageByUrl("url_not_cached") // miss
+	_ = cache.Add(secondImage)                   // evicted first, added second
+	_ = cache.Delete(secondImage)                // deleted
+
+	want := []EventType{EventAdded, EventHit, EventMiss, EventEvicted, EventAdded, EventDeleted}
+	if len(events) != len(want) {
+		t.Fatalf("Subscribe() got events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("Subscribe() got events = %v, want %v", events, want)
+			break
+		}
+	}
+	snapshot := stats.Snapshot()
+	if snapshot.Added != 2 || snapshot.Hits != 1 || snapshot.Misses != 1 || snapshot.Evicted != 1 || snapshot.Deleted != 1 {
+		t.Errorf("Subscribe() got stats = %+v", snapshot)
+	}
+	if snapshot.EvictedBytes != firstImage.Size {
+		t.Errorf("Subscribe() got evicted bytes = %v, want %v", snapshot.EvictedBytes, firstImage.Size)
+	}
+}
+
+func TestCache_ListenerReadsCache(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("ListenerReadsCache() create logger give error: %v", err)
+	}
+
+	cacheFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("ListenerReadsCache() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(cacheFolder)
+	cache := &Cache{
+		MaxSize:       2 * 1024,
+		CleanInterval: 5 * time.Minute,
+		Watermark:     0.5,
+		Folder:        cacheFolder,
+		Storage:       make([]*models.Image, 0),
+		Logger:        logger,
+		lock:          &sync.RWMutex{},
+	}
+	// Listener reads cache on every event, it deadlocks if event is emitted under cache lock
+	events := make(map[EventType]int)
+	cache.Subscribe(func(event Event) {
+		_, _, _ = cache.Usage()
+		_ = cache.Contains(event.Url)
+		events[event.Type] += 1
+	})
+	image := func(name string, fetchCount int) *models.Image {
+		img := &models.Image{Name: name, Url: "url_" + name, Size: 1024, FetchCount: fetchCount}
+		if err := ioutil.WriteFile(path.Join(cacheFolder, name), []byte(name), 0644); err != nil {
+			t.Fatalf("ListenerReadsCache() Cannot create image file: %v", err)
+		}
+		return img
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = cache.Add(image("a", 0))
+		_ = cache.Add(image("b", 1))
+		_ = cache.Add(image("c", 2)) // evicts a
+		_, _ = cache.GetImageByUrl("url_b")
+		_, _ = cache.GetImageByUrl("url_a")
+		_ = os.Remove(path.Join(cacheFolder, "b"))
+		_, _ = cache.GetImageByUrl("url_b") // evicts missing b
+		_ = cache.Add(image("d", 3))
+		_ = cache.RemoveOldest()
+		_ = cache.Add(image("e", 4))
+		_, _ = cache.Clean()
+		_ = cache.Delete(&models.Image{Name: "e", Url: "url_e"})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ListenerReadsCache() cache methods are blocked by listener")
+	}
+
+	want := map[EventType]int{EventAdded: 5, EventHit: 1, EventMiss: 2, EventEvicted: 2, EventExpired: 2, EventDeleted: 1}
+	for eventType, count := range want {
+		if events[eventType] != count {
+			t.Errorf("ListenerReadsCache() %v events = %v, want %v", eventType, events[eventType], count)
+		}
+	}
+}
+
+func TestCache_Clean(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("Clean() create logger give error: %v", err)
+	}
+	cacheFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("Clean() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(cacheFolder)
+
+	newCache := func(images ...*models.Image) *Cache {
+		cache := &Cache{
+			MaxSize:       10 * 1024 * 1024,
+			CleanInterval: 5 * time.Minute,
+			Watermark:     0.5,
+			Folder:        cacheFolder,
+			Storage:       make([]*models.Image, 0),
+			Logger:        logger,
+			lock:          &sync.RWMutex{},
+		}
+		for _, img := range images {
+			if err := cache.Add(img); err != nil {
+				t.Errorf("Clean() Cannot add %v to cache:%v", img.Name, err)
+			}
+		}
+		return cache
+	}
+	image := func(name string, size int64, fetchCount int) *models.Image {
+		return &models.Image{Name: name, MimeType: "image/jpeg", Url: "url_" + name, Size: size * 1024 * 1024, FetchCount: fetchCount}
+	}
+
+	tests := []struct {
+		name        string
+		cache       *Cache
+		wantRemoved int
+		wantSize    int64
+	}{
+		{name: "Clean EMPTY cache", cache: newCache(), wantRemoved: 0, wantSize: 0},
+		{name: "Clean cache below watermark", cache: newCache(image("a", 2, 0), image("b", 2, 0)), wantRemoved: 0, wantSize: 4 * 1024 * 1024},
+		{name: "Clean cache above watermark", cache: newCache(image("a", 3, 5), image("b", 3, 0), image("c", 3, 1)), wantRemoved: 2, wantSize: 3 * 1024 * 1024},
+		{name: "Clean keeps one image", cache: newCache(image("a", 8, 0)), wantRemoved: 0, wantSize: 8 * 1024 * 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			removed, err := tt.cache.Clean()
+			if err != nil {
+				t.Errorf("Clean() error = %v", err)
+				return
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("Clean() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if tt.cache.CurrentSize != tt.wantSize {
+				t.Errorf("Clean() size = %v, want %v", tt.cache.CurrentSize, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestCache_Close(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("Close() create logger give error: %v", err)
+	}
+
+	cacheFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("Close() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(cacheFolder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cache, err := NewCache(ctx, logger, 1024*1024, cacheFolder, time.Millisecond, 0, 0.8)
+	if err != nil {
+		t.Fatalf("Close() Cannot create cache instance:%v", err)
+	}
+	cancel()
+	<-cache.cleanerDone // cleaner must stop when context is done
+	cache.Close()
+	cache.Close() // second Close is noop
+}
+
+func TestCache_SharedImageFile(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("SharedImageFile() create logger give error: %v", err)
+	}
+
+	// Creating temp folder for cache
+	cacheFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("SharedImageFile() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(cacheFolder)
+
+	cache := &Cache{
+		MaxSize:       10 * 1024 * 1024,
+		CleanInterval: 5 * time.Minute,
+		Folder:        cacheFolder,
+		Storage:       make([]*models.Image, 0),
+		Logger:        logger,
+		lock:          &sync.RWMutex{},
+	}
+
+	// Same content fetched from two urls has same file name
+	mirrorImage := &models.Image{Name: "sharedblob", MimeType: "image/jpeg", Url: "url_mirror", Size: 1 * 1024 * 1024}
+	originImage := &models.Image{Name: "sharedblob", MimeType: "image/jpeg", Url: "url_origin", Size: 1 * 1024 * 1024}
+	blobPath := path.Join(cacheFolder, originImage.Name)
+	blobFile, err := os.Create(blobPath)
+	if err != nil{
+		t.Errorf("SharedImageFile() Cannot create blobFile:%v", err)
+	}
+	blobFile.Close()
+
+	_ = cache.Add(originImage)
+	_ = cache.Add(mirrorImage)
+	if cache.CurrentSize != originImage.Size {
+		t.Errorf("SharedImageFile() size = %v, want %v", cache.CurrentSize, originImage.Size)
+	}
+	if _, err := cache.GetImageByUrl(mirrorImage.Url); err != nil {
+		t.Errorf("SharedImageFile() mirror url is not in cache: %v", err)
+	}
+
+	_ = cache.Delete(originImage)
+	if _, err := os.Stat(blobPath); err != nil {
+		t.Errorf("SharedImageFile() file is removed while mirror url references it: %v", err)
+	}
+	if cache.CurrentSize != mirrorImage.Size {
+		t.Errorf("SharedImageFile() size = %v, want %v", cache.CurrentSize, mirrorImage.Size)
+	}
+
+	_ = cache.Delete(mirrorImage)
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Errorf("SharedImageFile() file is kept after last url is deleted")
+	}
+	if cache.CurrentSize != 0 {
+		t.Errorf("SharedImageFile() size = %v, want 0", cache.CurrentSize)
+	}
+}
+
+func TestCache_AddTempFile(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("AddTempFile() create logger give error: %v", err)
+	}
+
+	cacheFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("AddTempFile() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(cacheFolder)
+	cache := &Cache{
+		MaxSize:       2 * 1024,
+		CleanInterval: 5 * time.Minute,
+		Folder:        cacheFolder,
+		Storage:       make([]*models.Image, 0),
+		Logger:        logger,
+		lock:          &sync.RWMutex{},
+	}
+	blobPath := path.Join(cacheFolder, "sharedblob")
+	download := func(img *models.Image) {
+		file, err := ioutil.TempFile(cacheFolder, "fetch-*.tmp")
+		if err != nil {
+			t.Fatalf("AddTempFile() Cannot create temp file: %v", err)
+		}
+		file.Close()
+		img.TempFile = file.Name()
+	}
+
+	originImage := &models.Image{Name: "sharedblob", Url: "url_origin", Size: 1024}
+	download(originImage)
+	if err := cache.Add(originImage); err != nil || originImage.TempFile != "" {
+		t.Fatalf("AddTempFile() Add() error = %v, temp file = %v", err, originImage.TempFile)
+	}
+
+	// Same content is downloaded for another url while origin url is evicted
+	mirrorImage := &models.Image{Name: "sharedblob", Url: "url_mirror", Size: 1024}
+	download(mirrorImage)
+	_ = cache.Delete(originImage)
+	if err := cache.Add(mirrorImage); err != nil {
+		t.Fatalf("AddTempFile() Add() error = %v", err)
+	}
+	if _, err := os.Stat(blobPath); err != nil {
+		t.Errorf("AddTempFile() file of cached image is missing: %v", err)
+	}
+	if cache.CurrentSize != mirrorImage.Size {
+		t.Errorf("AddTempFile() size = %v, want %v", cache.CurrentSize, mirrorImage.Size)
+	}
+
+	// Image which is too big for cache is still moved, so it can be cropped
+	bigImage := &models.Image{Name: "bigblob", Url: "url_big", Size: 4 * 1024}
+	download(bigImage)
+	if err := cache.Add(bigImage); err == nil {
+		t.Errorf("AddTempFile() big image is added")
+	}
+	if _, err := os.Stat(path.Join(cacheFolder, bigImage.Name)); err != nil || bigImage.TempFile != "" {
+		t.Errorf("AddTempFile() big image is not moved: %v", err)
+	}
+
+	// Urls of same file are added and deleted concurrently, file of cached urls is never removed
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		img := &models.Image{Name: "sharedblob", Url: fmt.Sprintf("url_%v", i), Size: 1024}
+		download(img)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = cache.Add(img)
+			cache.lock.Lock()
+			_, _ = cache.delete(logger, mirrorImage)
+			cache.lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	if _, err := os.Stat(blobPath); err != nil {
+		t.Errorf("AddTempFile() file of %v cached urls is missing: %v", len(cache.Storage), err)
+	}
+	if cache.CurrentSize != 1024 {
+		t.Errorf("AddTempFile() size = %v, want 1024", cache.CurrentSize)
+	}
+}
+
+func TestCache_ExportImport(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("ExportImport() create logger give error: %v", err)
+	}
+
+	// Creating temp folders for source and destination caches
+	tempFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("ExportImport() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(tempFolder)
+	sourceFolder := path.Join(tempFolder, "source")
+	destinationFolder := path.Join(tempFolder, "destination")
+	for _, folder := range []string{sourceFolder, destinationFolder} {
+		if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+			t.Errorf("ExportImport() Cannot create cache folder at %v\n", folder)
+		}
+	}
+
+	source := &Cache{
+		MaxSize:       10 * 1024,
+		CleanInterval: 5 * time.Minute,
+		Folder:        sourceFolder,
+		Storage:       make([]*models.Image, 0),
+		Logger:        logger,
+		lock:          &sync.RWMutex{},
+	}
+	destination := &Cache{
+		MaxSize:       5 * 1024,
+		CleanInterval: 5 * time.Minute,
+		Folder:        destinationFolder,
+		Storage:       make([]*models.Image, 0),
+		Logger:        logger,
+		lock:          &sync.RWMutex{},
+	}
+
+	// File names are sha256 of content like in cutter service
+	addImage := func(url string, content []byte, fetchCount int) *models.Image {
+		sum := sha256.Sum256(content)
+		img := &models.Image{Name: hex.EncodeToString(sum[:]), MimeType: "image/jpeg", Url: url, Size: int64(len(content)), FetchCount: fetchCount}
+		if err := ioutil.WriteFile(path.Join(sourceFolder, img.Name), content, 0644); err != nil {
+			t.Errorf("ExportImport() Cannot write image file:%v", err)
+		}
+		if err := source.Add(img); err != nil {
+			t.Errorf("ExportImport() Cannot add image to source cache:%v", err)
+		}
+		return img
+	}
+	popular := addImage("url_popular", bytes.Repeat([]byte{1}, 3*1024), 10)
+	addImage("url_popular_mirror", bytes.Repeat([]byte{1}, 3*1024), 5)
+	addImage("url_rare", bytes.Repeat([]byte{2}, 3*1024), 0)
+
+	archive := new(bytes.Buffer)
+	if err := source.Export(archive); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	corrupted := bytes.Replace(archive.Bytes(), bytes.Repeat([]byte{2}, 1024), bytes.Repeat([]byte{3}, 1024), 1)
+
+	if _, err := destination.Import(bytes.NewReader(corrupted)); err == nil {
+		t.Errorf("Import() of corrupted snapshot must give error")
+	}
+	result, err := destination.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	// Rare image does not fit in destination cache
+	if result.Imported != 2 || result.Skipped != 1 {
+		t.Errorf("Import() result = %+v, want 2 imported and 1 skipped", result)
+	}
+	if destination.CurrentSize != popular.Size {
+		t.Errorf("Import() size = %v, want %v", destination.CurrentSize, popular.Size)
+	}
+	if _, err := destination.GetImageByUrl("url_popular_mirror"); err != nil {
+		t.Errorf("Import() image is not in destination cache: %v", err)
+	}
+}
+
+func TestCache_SaveLoadIndex(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("SaveLoadIndex() create logger give error: %v", err)
+	}
+
+	// Creating temp folder for cache
+	cacheFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("SaveLoadIndex() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(cacheFolder)
+
+	ctx := context.Background()
+	cache, err := NewCache(ctx, logger, 1024*1024, cacheFolder, 5*time.Minute, 0, 0.8)
+	if err != nil {
+		t.Fatalf("SaveLoadIndex() Cannot create cache instance:%v", err)
+	}
+	keptImage := &models.Image{Name: "keptblob", MimeType: "image/png", Url: "url_kept", Size: 1024, FetchCount: 3}
+	lostImage := &models.Image{Name: "lostblob", MimeType: "image/png", Url: "url_lost", Size: 1024}
+	for _, img := range []*models.Image{keptImage, lostImage} {
+		file, err := os.Create(path.Join(cacheFolder, img.Name))
+		if err != nil {
+			t.Errorf("SaveLoadIndex() Cannot create image file:%v", err)
+		}
+		file.Close()
+		_ = cache.Add(img)
+	}
+	cache.Close() // index is saved on close
+
+	// Files of interrupted downloads and missing images are dropped on load
+	_ = os.Remove(path.Join(cacheFolder, lostImage.Name))
+	tempFile, err := os.Create(path.Join(cacheFolder, "fetch-1.tmp"))
+	if err != nil {
+		t.Errorf("SaveLoadIndex() Cannot create temp file:%v", err)
+	}
+	tempFile.Close()
+
+	restored, err := NewCache(ctx, logger, 1024*1024, cacheFolder, 5*time.Minute, 0, 0.8)
+	if err != nil {
+		t.Fatalf("SaveLoadIndex() Cannot create cache instance:%v", err)
+	}
+	defer restored.Close()
+	<-restored.indexDone // index is loaded in background
+	img, err := restored.GetImageByUrl(keptImage.Url)
+	if err != nil {
+		t.Errorf("SaveLoadIndex() image is not restored: %v", err)
+	} else if img.FetchCount != keptImage.FetchCount {
+		t.Errorf("SaveLoadIndex() FetchCount = %v, want %v", img.FetchCount, keptImage.FetchCount)
+	}
+	if _, err := restored.GetImageByUrl(lostImage.Url); err == nil {
+		t.Errorf("SaveLoadIndex() image without file is restored")
+	}
+	if restored.CurrentSize != keptImage.Size {
+		t.Errorf("SaveLoadIndex() size = %v, want %v", restored.CurrentSize, keptImage.Size)
+	}
+	if _, err := os.Stat(tempFile.Name()); !os.IsNotExist(err) {
+		t.Errorf("SaveLoadIndex() temp file is kept")
+	}
+}
+
+func TestCache_Contains(t *testing.T) {
+	// Logger
+	logger, err := logging.CreateLogger(&cfg.Logger{Level: "info", Encoding: "console", OutputPaths:[]string{"stdout"}, ErrorOutputPaths:[]string{"stderr"}})
+	if err != nil {
+		t.Errorf("Contains() create logger give error: %v", err)
+	}
+
+	cacheFolder, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatalf("Contains() Cannot create cache folder: %v", err)
+	}
+	defer os.RemoveAll(cacheFolder)
+	cache := &Cache{
+		MaxSize:       1024 * 1024,
+		CleanInterval: 5 * time.Minute,
+		Folder:        cacheFolder,
+		Storage:       make([]*models.Image, 0),
+		Logger:        logger,
+		lock:          &sync.RWMutex{},
+	}
+	stats := &Stats{}
+	cache.Subscribe(stats.Listen)
+
+	onDiskImage := &models.Image{Name: "ondiskblob", MimeType: "image/png", Url: "url_on_disk", Size: 1024}
+	lostImage := &models.Image{Name: "lostblob", MimeType: "image/png", Url: "url_lost", Size: 1024}
+	file, err := os.Create(path.Join(cacheFolder, onDiskImage.Name))
+	if err != nil {
+		t.Errorf("Contains() Cannot create image file:%v", err)
+	}
+	file.Close()
+	for _, img := range []*models.Image{onDiskImage, lostImage} {
+		if err := cache.Add(img); err != nil {
+			t.Errorf("Contains() Cannot add %v to cache:%v", img.Name, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{name: "Image in cache and on disk", url: onDiskImage.Url, want: true},
+		{name: "Image in cache without file", url: lostImage.Url, want: false},
+		{name: "Image not in cache", url: "url_unknown", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cache.Contains(tt.url); got != tt.want {
+				t.Errorf("Contains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	snapshot := stats.Snapshot()
+	if snapshot.Hits != 0 || snapshot.Misses != 0 {
+		t.Errorf("Contains() emitted %v hits and %v misses, want none", snapshot.Hits, snapshot.Misses)
+	}
+}
@@ -0,0 +1,235 @@
+package lru
+
+import (
+	"ImageCutter/pkg/models"
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot archive layout:
+//
+//	blobs/<name> - image files, each file is stored once
+//	index.json   - cached images and sha256 of each file, written last
+const (
+	snapshotIndexName  = "index.json"
+	snapshotBlobPrefix = "blobs/"
+	snapshotVersion    = 1
+)
+
+type snapshotIndex struct {
+	Version   int               `json:"version"`
+	Images    []*models.Image   `json:"images"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// Export writes cache index and image files to w as tar archive
+func (cc *Cache) Export(w io.Writer) error {
+	cc.lock.RLock()
+	images := make([]*models.Image, len(cc.Storage))
+	copy(images, cc.Storage)
+	cc.lock.RUnlock()
+
+	tw := tar.NewWriter(w)
+	index := snapshotIndex{
+		Version:   snapshotVersion,
+		Images:    make([]*models.Image, 0, len(images)),
+		Checksums: make(map[string]string),
+	}
+	for _, img := range images {
+		if _, ok := index.Checksums[img.Name]; !ok {
+			checksum, err := cc.exportFile(tw, img.Name)
+			if os.IsNotExist(err) {
+				cc.Logger.Sugar().Warnf("Image %v is not found on disk and will not be exported", img.Name)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			index.Checksums[img.Name] = checksum
+		}
+		index.Images = append(index.Images, img)
+	}
+
+	body, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: snapshotIndexName, Mode: 0644, Size: int64(len(body)), ModTime: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(body); err != nil {
+		return err
+	}
+	cc.Logger.Sugar().Infof("Cache snapshot with %v images and %v files was exported", len(index.Images), len(index.Checksums))
+	return tw.Close()
+}
+
+// exportFile writes image file to tar and returns its sha256
+func (cc *Cache) exportFile(tw *tar.Writer, name string) (string, error) {
+	file, err := os.Open(filepath.Join(cc.Folder, name))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: snapshotBlobPrefix + name, Mode: 0644, Size: stat.Size(), ModTime: stat.ModTime()})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, hash), file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Import reads tar archive made by Export and adds its images to cache.
+// Every file must be named by sha256 of its content, and all files are checked
+// against checksums from index before anything is added.
+// Most fetched images are imported first. Images which do not fit in free cache space
+// and urls which are already cached are skipped, so existing images are never evicted
+func (cc *Cache) Import(r io.Reader) (*ImportResult, error) {
+	var index *snapshotIndex
+	files := make(map[string]string)     // name -> temp file path
+	checksums := make(map[string]string) // name -> calculated sha256
+	sizes := make(map[string]int64)      // name -> file size
+	defer func() {
+		for _, tempPath := range files {
+			if _, err := os.Stat(tempPath); err == nil {
+				_ = os.Remove(tempPath)
+			}
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot archive give error: %v", err)
+		}
+		switch {
+		case header.Name == snapshotIndexName:
+			index = &snapshotIndex{}
+			if err := json.NewDecoder(tr).Decode(index); err != nil {
+				return nil, fmt.Errorf("decoding snapshot index give error: %v", err)
+			}
+		case strings.HasPrefix(header.Name, snapshotBlobPrefix):
+			name := strings.TrimPrefix(header.Name, snapshotBlobPrefix)
+			if name == "" || name != path.Base(name) || name == ".." {
+				return nil, fmt.Errorf("snapshot contains invalid file name: %v", header.Name)
+			}
+			if previous, ok := files[name]; ok {
+				_ = os.Remove(previous)
+			}
+			tempPath, checksum, err := cc.importFile(tr)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = tempPath
+			// File name is sha256 of content, so archive cannot map urls to forged content
+			if checksum != name {
+				return nil, fmt.Errorf("content of file %v does not match its name, sha256 is %v", name, checksum)
+			}
+			checksums[name] = checksum
+			sizes[name] = header.Size
+		default:
+			cc.Logger.Sugar().Warnf("Unknown snapshot entry %v is skipped", header.Name)
+		}
+	}
+	if index == nil {
+		return nil, errors.New("snapshot index is not found in archive")
+	}
+	if index.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot version %v is not supported", index.Version)
+	}
+
+	// Verify all files before touching cache
+	for _, img := range index.Images {
+		checksum, ok := checksums[img.Name]
+		if !ok {
+			return nil, fmt.Errorf("file %v of image %v is not found in snapshot", img.Name, img.Url)
+		}
+		if checksum != index.Checksums[img.Name] {
+			return nil, fmt.Errorf("checksum mismatch for file %v: %v vs %v", img.Name, checksum, index.Checksums[img.Name])
+		}
+		img.Size = sizes[img.Name]
+	}
+
+	sort.SliceStable(index.Images, func(i, j int) bool {
+		return index.Images[i].FetchCount > index.Images[j].FetchCount
+	})
+	result := &ImportResult{}
+	for _, img := range index.Images {
+		if !cc.fits(img) {
+			result.Skipped += 1
+			continue
+		}
+		imagePath := filepath.Join(cc.Folder, img.Name)
+		if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+			if err := os.Rename(files[img.Name], imagePath); err != nil {
+				return result, fmt.Errorf("moving imported file to %v give error: %v", imagePath, err)
+			}
+		}
+		if err := cc.Add(img); err != nil {
+			return result, err
+		}
+		result.Imported += 1
+	}
+	cc.Logger.Sugar().Infof("Cache snapshot was imported: %v images added, %v skipped", result.Imported, result.Skipped)
+	return result, nil
+}
+
+// importFile copies tar entry to temp file in cache folder and returns its path and sha256
+func (cc *Cache) importFile(r io.Reader) (string, string, error) {
+	tempFile, err := ioutil.TempFile(cc.Folder, "import-*.tmp")
+	if err != nil {
+		return "", "", err
+	}
+	defer tempFile.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tempFile, hash), r); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return "", "", err
+	}
+	return tempFile.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fits reports whether image url is not cached yet and image fits in free cache space
+func (cc *Cache) fits(img *models.Image) bool {
+	cc.lock.RLock()
+	defer cc.lock.RUnlock()
+	for _, cached := range cc.Storage {
+		if cached.Url == img.Url {
+			return false
+		}
+	}
+	if cc.refs[img.Name] > 0 {
+		return true
+	}
+	return cc.CurrentSize+img.Size <= cc.MaxSize
+}
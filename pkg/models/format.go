@@ -0,0 +1,64 @@
+package models
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+)
+
+// Image formats detected by magic bytes
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatTIFF = "tiff"
+	FormatWebP = "webp"
+	FormatBMP  = "bmp"
+)
+
+// DetectFormat returns format of image by first bytes of file, or empty string if format is not supported
+func DetectFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return FormatJPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return FormatGIF
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return FormatTIFF
+	case len(head) >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return FormatWebP
+	case bytes.HasPrefix(head, []byte("BM")):
+		return FormatBMP
+	}
+	return ""
+}
+
+// FormatOf returns image format of mime type, or empty string if mime type is not supported image
+func FormatOf(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(mediaType) {
+	case "image/jpeg", "image/jpg", "image/pjpeg":
+		return FormatJPEG
+	case "image/png", "image/x-png":
+		return FormatPNG
+	case "image/gif":
+		return FormatGIF
+	case "image/tiff", "image/tif":
+		return FormatTIFF
+	case "image/webp":
+		return FormatWebP
+	case "image/bmp", "image/x-bmp", "image/x-ms-bmp":
+		return FormatBMP
+	}
+	return ""
+}
+
+// MimeTypeOf returns mime type of image format
+func MimeTypeOf(format string) string {
+	return "image/" + format
+}
@@ -0,0 +1,60 @@
+package models
+
+import "testing"
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{name: "JPEG", head: []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), want: FormatJPEG},
+		{name: "PNG", head: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), want: FormatPNG},
+		{name: "GIF87a", head: []byte("GIF87a\x01\x00\x01\x00"), want: FormatGIF},
+		{name: "GIF89a", head: []byte("GIF89a\x01\x00\x01\x00"), want: FormatGIF},
+		{name: "TIFF little endian", head: []byte("II*\x00\x08\x00\x00\x00"), want: FormatTIFF},
+		{name: "TIFF big endian", head: []byte("MM\x00*\x00\x00\x00\x08"), want: FormatTIFF},
+		{name: "WebP", head: []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), want: FormatWebP},
+		{name: "BMP", head: []byte("BM\x36\x00\x00\x00"), want: FormatBMP},
+		{name: "Other RIFF file", head: []byte("RIFF\x24\x00\x00\x00WAVEfmt "), want: ""},
+		{name: "HTML error page", head: []byte("<!DOCTYPE html><html>"), want: ""},
+		{name: "SVG", head: []byte("<svg xmlns=\"http://www.w3.org/2000/svg\">"), want: ""},
+		{name: "Unknown bytes", head: []byte("\x00\x01\x02\x03\x04\x05\x06\x07"), want: ""},
+		{name: "Empty", head: []byte{}, want: ""},
+		{name: "Truncated JPEG", head: []byte("\xff\xd8"), want: ""},
+		{name: "Truncated PNG", head: []byte("\x89PNG\r\n"), want: ""},
+		{name: "Truncated GIF", head: []byte("GIF8"), want: ""},
+		{name: "Truncated WebP", head: []byte("RIFF\x24\x00\x00\x00WE"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat(tt.head); got != tt.want {
+				t.Errorf("DetectFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		want     string
+	}{
+		{name: "JPEG", mimeType: "image/jpeg", want: FormatJPEG},
+		{name: "Alias with parameters", mimeType: "image/JPG; charset=binary", want: FormatJPEG},
+		{name: "PNG alias", mimeType: "image/x-png", want: FormatPNG},
+		{name: "WebP", mimeType: "image/webp", want: FormatWebP},
+		{name: "Unsupported image", mimeType: "image/svg+xml", want: ""},
+		{name: "Not image", mimeType: "text/html", want: ""},
+		{name: "Broken", mimeType: "image/png;;", want: ""},
+		{name: "Empty", mimeType: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatOf(tt.mimeType); got != tt.want {
+				t.Errorf("FormatOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
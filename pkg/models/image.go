@@ -3,8 +3,10 @@ package models
 type Image struct {
 	Name string
 	MimeType string
+	Format string // detected by content, one of Format constants
 	Url string
 	Size int64
 	Headers map[string]string
 	FetchCount int
+	TempFile string `json:"-"` // downloaded file which is moved to Name when image is added to cache
 }
\ No newline at end of file
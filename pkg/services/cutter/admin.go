@@ -0,0 +1,121 @@
+package cutter
+
+import (
+	"ImageCutter/pkg/lru"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Snapshot upload may exceed cache size by tar headers and index
+const snapshotOverhead = 32 << 20
+
+// adminMiddleware requires "Authorization: Bearer <token>" with admin token
+func (cs *CutterService) adminMiddleware(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + cs.Config.Cutter.Admin.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			cs.log(r.Context()).Warn("Admin request without valid token is rejected")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Valid admin token is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes value as JSON response with given code
+func (cs *CutterService) writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		cs.Logger.Sugar().Errorf("Unable to write JSON response: %v", err)
+	}
+}
+
+// CacheStatsHandler returns cache event counters and current cache size
+func (cs *CutterService) CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	currentSize, maxSize, images := cs.Cache.Usage()
+	cs.writeJSON(w, http.StatusOK, struct {
+		Events      lru.Stats `json:"events"`
+		CurrentSize int64     `json:"current_size"`
+		MaxSize     int64     `json:"max_size"`
+		Images      int       `json:"images"`
+	}{
+		Events:      cs.CacheStats.Snapshot(),
+		CurrentSize: currentSize,
+		MaxSize:     maxSize,
+		Images:      images,
+	})
+}
+
+// CacheCleanHandler shrinks cache to configured watermark right now
+func (cs *CutterService) CacheCleanHandler(w http.ResponseWriter, r *http.Request) {
+	removed, err := cs.Cache.Clean()
+	if err != nil {
+		cs.Logger.Sugar().Errorf("Cleaning cache on demand give error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	currentSize, maxSize, images := cs.Cache.Usage()
+	cs.writeJSON(w, http.StatusOK, struct {
+		Removed     int   `json:"removed"`
+		CurrentSize int64 `json:"current_size"`
+		MaxSize     int64 `json:"max_size"`
+		Images      int   `json:"images"`
+	}{
+		Removed:     removed,
+		CurrentSize: currentSize,
+		MaxSize:     maxSize,
+		Images:      images,
+	})
+}
+
+// CacheExportHandler writes cache snapshot as tar archive
+func (cs *CutterService) CacheExportHandler(w http.ResponseWriter, r *http.Request) {
+	cs.extendDeadlines(w)
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", `attachment; filename="cache.tar"`)
+	if err := cs.Cache.Export(w); err != nil {
+		// Headers are already sent, so client will get truncated archive
+		cs.Logger.Sugar().Errorf("Exporting cache snapshot give error: %v", err)
+	}
+}
+
+// CacheImportHandler adds images from cache snapshot in request body to cache.
+// Archive size is limited by cache size, because all files are written to disk before import
+func (cs *CutterService) CacheImportHandler(w http.ResponseWriter, r *http.Request) {
+	cs.extendDeadlines(w)
+	body := http.MaxBytesReader(w, r.Body, int64(cs.Config.Cutter.Cache.Size)+snapshotOverhead)
+	result, err := cs.Cache.Import(body)
+	if err != nil {
+		mess := fmt.Sprintf("Importing cache snapshot give error: %v", err)
+		cs.Logger.Error(mess)
+		http.Error(w, mess, http.StatusBadRequest)
+		return
+	}
+	cs.writeJSON(w, http.StatusOK, result)
+}
+
+// extendDeadlines replaces Server read and write timeouts for snapshot transfer, otherwise big archive
+// is cut off. It is called after admin token check, so only admin may hold connection that long
+func (cs *CutterService) extendDeadlines(w http.ResponseWriter) {
+	var deadline time.Time
+	if timeout := cs.Config.Cutter.Admin.SnapshotTimeout.Duration(); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		cs.Logger.Sugar().Warnf("Extending read deadline of snapshot transfer give error: %v", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		cs.Logger.Sugar().Warnf("Extending write deadline of snapshot transfer give error: %v", err)
+	}
+}
+
+// BreakersHandler returns circuit breaker states of remote hosts
+func (cs *CutterService) BreakersHandler(w http.ResponseWriter, r *http.Request) {
+	cs.writeJSON(w, http.StatusOK, cs.Breakers.States())
+}
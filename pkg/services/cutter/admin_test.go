@@ -0,0 +1,71 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCutterService_extendDeadlines(t *testing.T) {
+	tests := []struct {
+		name            string
+		snapshotTimeout time.Duration
+		extend          bool
+		wantOK          bool
+	}{
+		{name: "Server timeouts cut off slow upload", extend: false, wantOK: false},
+		{name: "Snapshot timeout", snapshotTimeout: time.Minute, extend: true, wantOK: true},
+		{name: "Snapshot timeout is disabled", snapshotTimeout: 0, extend: true, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &cfg.CutterConfig{}
+			config.Cutter.Admin.SnapshotTimeout = cfg.Duration(tt.snapshotTimeout)
+			cs := &CutterService{Logger: zap.NewNop(), Config: config}
+
+			// Handler is wrapped like in middleware, so deadlines are set through statusWriter
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sw := &statusWriter{ResponseWriter: w}
+				if tt.extend {
+					cs.extendDeadlines(sw)
+				}
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					http.Error(sw, err.Error(), http.StatusBadRequest)
+					return
+				}
+				time.Sleep(150 * time.Millisecond)
+				_, _ = sw.Write(body)
+			}))
+			server.Config.ReadTimeout = 100 * time.Millisecond
+			server.Config.WriteTimeout = 100 * time.Millisecond
+			server.Start()
+			defer server.Close()
+
+			// Archive is uploaded slower than server read timeout
+			reader, writer := io.Pipe()
+			go func() {
+				for i := 0; i < 3; i++ {
+					_, _ = writer.Write([]byte("chunk"))
+					time.Sleep(100 * time.Millisecond)
+				}
+				_ = writer.Close()
+			}()
+			resp, err := http.Post(server.URL, "application/x-tar", reader)
+			ok := err == nil && resp.StatusCode == http.StatusOK
+			if err == nil {
+				body, err := ioutil.ReadAll(resp.Body)
+				ok = ok && err == nil && string(body) == "chunkchunkchunk"
+				_ = resp.Body.Close()
+			}
+			if ok != tt.wantOK {
+				t.Errorf("slow snapshot transfer succeeded = %v, want %v (error %v)", ok, tt.wantOK, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gorilla/mux"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// APIKey is credential of internal consumer with its limits and usage counters
+type APIKey struct {
+	Name    string
+	config  cfg.APIKey
+	limiter *HostLimiter // nil if rate is not limited
+	usage   KeyUsage
+	lock    sync.Mutex
+}
+
+// KeyUsage counts requests made with API key
+type KeyUsage struct {
+	Name        string           `json:"name"`
+	Requests    int64            `json:"requests"`     // accepted requests
+	Operations  map[string]int64 `json:"operations"`   // accepted requests by operation
+	Forbidden   int64            `json:"forbidden"`    // rejected by operation, source or size
+	RateLimited int64            `json:"rate_limited"` // rejected by rate limit
+	LastUsed    time.Time        `json:"last_used,omitempty"`
+}
+
+// Results of requests made with API key
+const (
+	keyAccepted    = "accepted"
+	keyForbidden   = "forbidden"
+	keyRateLimited = "rate_limited"
+)
+
+func (k *APIKey) count(operation string, result string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.usage.LastUsed = time.Now()
+	switch result {
+	case keyAccepted:
+		k.usage.Requests += 1
+		k.usage.Operations[operation] += 1
+	case keyForbidden:
+		k.usage.Forbidden += 1
+	case keyRateLimited:
+		k.usage.RateLimited += 1
+	}
+}
+
+// Usage returns copy of usage counters
+func (k *APIKey) Usage() KeyUsage {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	usage := k.usage
+	usage.Operations = make(map[string]int64, len(k.usage.Operations))
+	for operation, count := range k.usage.Operations {
+		usage.Operations[operation] = count
+	}
+	return usage
+}
+
+// allows checks operation and crop arguments of request. Returns reason if request is not allowed
+func (k *APIKey) allows(cs *CutterService, operation string, args map[string]string) (string, bool) {
+	operations := k.config.Operations
+	if len(operations) == 0 {
+		operations = []string{cfg.OperationCrop, cfg.OperationCache}
+	}
+	if !containsString(operations, operation) {
+		return fmt.Sprintf("operation %v is not allowed", operation), false
+	}
+
+	if url, ok := args["url"]; ok {
+		source := strings.SplitN(url, "/", 2)[0]
+		if _, ok := cs.Sources[source]; ok {
+			if len(k.config.Sources) > 0 && !containsString(k.config.Sources, source) {
+				return fmt.Sprintf("source %v is not allowed", source), false
+			}
+		} else if !k.config.RawUrls {
+			return "remote image urls are not allowed", false
+		}
+	}
+
+	// Invalid sizes are rejected by handler. Zero width or height is computed from original,
+	// so handler checks size of cropped image again
+	width, _ := strconv.Atoi(args["width"])
+	height, _ := strconv.Atoi(args["height"])
+	return k.allowsSize(width, height)
+}
+
+// allowsSize checks size of cropped image. Returns reason if size is not allowed
+func (k *APIKey) allowsSize(width int, height int) (string, bool) {
+	if k.config.MaxWidth > 0 && width > k.config.MaxWidth {
+		return fmt.Sprintf("width %v is higher than allowed %v", width, k.config.MaxWidth), false
+	}
+	if k.config.MaxHeight > 0 && height > k.config.MaxHeight {
+		return fmt.Sprintf("height %v is higher than allowed %v", height, k.config.MaxHeight), false
+	}
+	return "", true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeys finds API keys of requests
+type APIKeys struct {
+	unauthorized int64 // requests without valid key, first field like CropLimiter.waiting
+	Header       string
+	QueryParam   string
+	keys         []*APIKey // sorted by name
+}
+
+// NewAPIKeys creates keys from config. Returns nil if no keys are configured
+func NewAPIKeys(config cfg.Auth) *APIKeys {
+	if len(config.Keys) == 0 {
+		return nil
+	}
+	keys := &APIKeys{Header: config.Header, QueryParam: config.QueryParam}
+	for name, keyConfig := range config.Keys {
+		key := &APIKey{Name: name, config: keyConfig, usage: KeyUsage{Name: name, Operations: make(map[string]int64)}}
+		if keyConfig.RateLimit > 0 {
+			key.limiter = newHostLimiter(name, keyConfig.RateLimit, keyConfig.RateBurst, 0)
+		}
+		keys.keys = append(keys.keys, key)
+	}
+	sort.Slice(keys.keys, func(i, j int) bool {
+		return keys.keys[i].Name < keys.keys[j].Name
+	})
+	return keys
+}
+
+// Find returns key of request or nil. Secrets are compared in constant time
+func (ks *APIKeys) Find(r *http.Request) *APIKey {
+	secret := r.Header.Get(ks.Header)
+	if secret == "" && ks.QueryParam != "" {
+		secret = r.URL.Query().Get(ks.QueryParam)
+	}
+	if secret == "" {
+		return nil
+	}
+	var found *APIKey
+	for _, key := range ks.keys {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(key.config.Key)) == 1 {
+			found = key
+		}
+	}
+	return found
+}
+
+// Usage returns usage counters of all keys sorted by name and number of requests without valid key.
+// Nil APIKeys has no usage
+func (ks *APIKeys) Usage() ([]KeyUsage, int64) {
+	if ks == nil {
+		return []KeyUsage{}, 0
+	}
+	usage := make([]KeyUsage, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		usage = append(usage, key.Usage())
+	}
+	return usage, atomic.LoadInt64(&ks.unauthorized)
+}
+
+// operationOf returns operation of request path. Other paths, e.g. /healthz and /metrics, are not limited
+func operationOf(path string) (string, bool) {
+	switch {
+	case strings.HasPrefix(path, "/crop/"):
+		return cfg.OperationCrop, true
+	case strings.HasPrefix(path, "/cache/"):
+		return cfg.OperationCache, true
+	case strings.HasPrefix(path, "/admin/"):
+		return cfg.OperationAdmin, true
+	}
+	return "", false
+}
+
+// authMiddleware checks API key, its allowed operations, sources, sizes and rate limit.
+// Returns 401 without valid key, 403 if request is not allowed for key and 429 if key rate is exceeded.
+// Admin routes are checked by adminMiddleware
+func (cs *CutterService) authMiddleware(next http.Handler) http.Handler {
+	if cs.APIKeys == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation, ok := operationOf(r.URL.Path)
+		if !ok || operation == cfg.OperationAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger := cs.log(r.Context())
+		key := cs.APIKeys.Find(r)
+		if key == nil {
+			atomic.AddInt64(&cs.APIKeys.unauthorized, 1)
+			logger.Warn("Request without valid API key is rejected")
+			http.Error(w, "Valid API key is required", http.StatusUnauthorized)
+			return
+		}
+		info := requestInfoFrom(r.Context())
+		info.APIKey = key.Name
+		info.key = key
+
+		if reason, ok := key.allows(cs, operation, mux.Vars(r)); !ok {
+			key.count(operation, keyForbidden)
+			mess := fmt.Sprintf("API key %v: %v", key.Name, reason)
+			logger.Warn(mess)
+			http.Error(w, mess, http.StatusForbidden)
+			return
+		}
+		if key.limiter != nil {
+			if wait, ok := key.limiter.reserve(0); !ok {
+				key.count(operation, keyRateLimited)
+				mess := fmt.Sprintf("API key %v: rate limit exceeded", key.Name)
+				logger.Warn(mess)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, mess, http.StatusTooManyRequests)
+				return
+			}
+		}
+		key.count(operation, keyAccepted)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIKeysHandler returns usage counters of API keys
+func (cs *CutterService) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	usage, unauthorized := cs.APIKeys.Usage()
+	cs.writeJSON(w, http.StatusOK, struct {
+		Keys         []KeyUsage `json:"keys"`
+		Unauthorized int64      `json:"unauthorized"`
+	}{
+		Keys:         usage,
+		Unauthorized: unauthorized,
+	})
+}
@@ -0,0 +1,251 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"ImageCutter/pkg/cropper"
+	"ImageCutter/pkg/lru"
+	"ImageCutter/pkg/models"
+	"bytes"
+	"context"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testAPIKeys() *APIKeys {
+	return NewAPIKeys(cfg.Auth{
+		Header:     "X-Api-Key",
+		QueryParam: "key",
+		Keys: map[string]cfg.APIKey{
+			"shop":    {Key: "shop-secret", Sources: []string{"catalog"}, MaxWidth: 1000, MaxHeight: 500},
+			"mobile":  {Key: "mobile-secret", RawUrls: true},
+			"checker": {Key: "checker-secret", Operations: []string{cfg.OperationCache}},
+			"limited": {Key: "limited-secret", RateLimit: 1, RateBurst: 1},
+		},
+	})
+}
+
+func TestNewAPIKeys(t *testing.T) {
+	if keys := NewAPIKeys(cfg.Auth{Header: "X-Api-Key"}); keys != nil {
+		t.Errorf("NewAPIKeys() without keys = %+v, want nil", keys)
+	}
+	usage, unauthorized := testAPIKeys().Usage()
+	if len(usage) != 4 || usage[0].Name != "checker" || usage[3].Name != "shop" || unauthorized != 0 {
+		t.Errorf("Usage() = %+v, %v, want keys sorted by name", usage, unauthorized)
+	}
+}
+
+func TestAPIKeys_Find(t *testing.T) {
+	tests := []struct {
+		name       string
+		queryParam string
+		header     string
+		url        string
+		want       string // name of found key
+	}{
+		{name: "Key in header", header: "shop-secret", url: "/crop/1/1/catalog/a.jpg", want: "shop"},
+		{name: "Key in query", url: "/crop/1/1/catalog/a.jpg?key=mobile-secret", want: "mobile"},
+		{name: "Header wins over query", header: "shop-secret", url: "/crop/1/1/catalog/a.jpg?key=mobile-secret", want: "shop"},
+		{name: "Query param is disabled", queryParam: "-", url: "/crop/1/1/catalog/a.jpg?key=mobile-secret", want: ""},
+		{name: "Unknown key", header: "other-secret", url: "/crop/1/1/catalog/a.jpg", want: ""},
+		{name: "Prefix of key", header: "shop", url: "/crop/1/1/catalog/a.jpg", want: ""},
+		{name: "No key", url: "/crop/1/1/catalog/a.jpg", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := testAPIKeys()
+			if tt.queryParam == "-" {
+				keys.QueryParam = ""
+			}
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Api-Key", tt.header)
+			}
+			got := keys.Find(r)
+			if (got == nil) != (tt.want == "") || got != nil && got.Name != tt.want {
+				t.Errorf("Find() = %+v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKey_allows(t *testing.T) {
+	cs := &CutterService{Sources: map[string]*Source{"catalog": {Name: "catalog"}, "avatars": {Name: "avatars"}}}
+	keys := testAPIKeys()
+	key := func(name string) *APIKey {
+		for _, key := range keys.keys {
+			if key.Name == name {
+				return key
+			}
+		}
+		t.Fatalf("key %v is not found", name)
+		return nil
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		operation string
+		args      map[string]string
+		want      bool
+	}{
+		{name: "Crop is allowed by default", key: "mobile", operation: cfg.OperationCrop, args: map[string]string{"url": "avatars/a.jpg"}, want: true},
+		{name: "Cache is allowed by default", key: "mobile", operation: cfg.OperationCache, args: map[string]string{"url": "avatars/a.jpg"}, want: true},
+		{name: "Admin is not allowed by default", key: "mobile", operation: cfg.OperationAdmin, want: false},
+		{name: "Operation from list", key: "checker", operation: cfg.OperationCache, args: map[string]string{"url": "catalog/a.jpg"}, want: true},
+		{name: "Operation not in list", key: "checker", operation: cfg.OperationCrop, args: map[string]string{"url": "catalog/a.jpg"}, want: false},
+		{name: "Allowed source", key: "shop", operation: cfg.OperationCrop, args: map[string]string{"url": "catalog/shoes/a.jpg", "width": "100", "height": "100"}, want: true},
+		{name: "Source not in list", key: "shop", operation: cfg.OperationCrop, args: map[string]string{"url": "avatars/a.jpg", "width": "100", "height": "100"}, want: false},
+		{name: "Raw url is not allowed", key: "shop", operation: cfg.OperationCrop, args: map[string]string{"url": "http:/example.com/a.jpg"}, want: false},
+		{name: "Raw url is allowed", key: "mobile", operation: cfg.OperationCrop, args: map[string]string{"url": "http:/example.com/a.jpg"}, want: true},
+		{name: "Max size", key: "shop", operation: cfg.OperationCrop, args: map[string]string{"url": "catalog/a.jpg", "width": "1000", "height": "500"}, want: true},
+		{name: "Width above limit", key: "shop", operation: cfg.OperationCrop, args: map[string]string{"url": "catalog/a.jpg", "width": "1001", "height": "500"}, want: false},
+		{name: "Height above limit", key: "shop", operation: cfg.OperationCrop, args: map[string]string{"url": "catalog/a.jpg", "width": "1000", "height": "501"}, want: false},
+		{name: "Invalid size is left to handler", key: "shop", operation: cfg.OperationCrop, args: map[string]string{"url": "catalog/a.jpg", "width": "wide", "height": "500"}, want: true},
+		{name: "No size limit", key: "mobile", operation: cfg.OperationCrop, args: map[string]string{"url": "avatars/a.jpg", "width": "10000", "height": "10000"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, got := key(tt.key).allows(cs, tt.operation, tt.args)
+			if got != tt.want {
+				t.Errorf("allows() = %v (%v), want %v", got, reason, tt.want)
+			}
+			if !got && reason == "" {
+				t.Errorf("allows() gives no reason")
+			}
+		})
+	}
+}
+
+func TestCutterService_authMiddleware(t *testing.T) {
+	cs := &CutterService{Logger: zap.NewNop(), Sources: map[string]*Source{"catalog": {Name: "catalog"}}, APIKeys: testAPIKeys()}
+	router := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/crop/{width}/{height}/{url:(?:.+)}", ok)
+	router.HandleFunc("/healthz", ok)
+	router.Use(cs.authMiddleware)
+
+	tests := []struct {
+		name string
+		key  string
+		url  string
+		want int
+	}{
+		{name: "Health check without key", url: "/healthz", want: http.StatusOK},
+		{name: "Crop without key", url: "/crop/100/100/catalog/a.jpg", want: http.StatusUnauthorized},
+		{name: "Crop with unknown key", key: "other-secret", url: "/crop/100/100/catalog/a.jpg", want: http.StatusUnauthorized},
+		{name: "Allowed crop", key: "shop-secret", url: "/crop/100/100/catalog/a.jpg", want: http.StatusOK},
+		{name: "Crop above size limit", key: "shop-secret", url: "/crop/2000/100/catalog/a.jpg", want: http.StatusForbidden},
+		{name: "Crop in rate limit", key: "limited-secret", url: "/crop/100/100/catalog/a.jpg", want: http.StatusOK},
+		{name: "Crop above rate limit", key: "limited-secret", url: "/crop/100/100/catalog/a.jpg", want: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.key != "" {
+				r.Header.Set("X-Api-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("authMiddleware() code = %v, want %v", w.Code, tt.want)
+			}
+			if tt.want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Errorf("authMiddleware() does not set Retry-After")
+			}
+		})
+	}
+
+	usage, unauthorized := cs.APIKeys.Usage()
+	if unauthorized != 2 {
+		t.Errorf("Usage() unauthorized = %v, want 2", unauthorized)
+	}
+	for _, u := range usage {
+		switch u.Name {
+		case "shop":
+			if u.Requests != 1 || u.Operations[cfg.OperationCrop] != 1 || u.Forbidden != 1 {
+				t.Errorf("Usage() of shop = %+v", u)
+			}
+		case "limited":
+			if u.Requests != 1 || u.RateLimited != 1 {
+				t.Errorf("Usage() of limited = %+v", u)
+			}
+		}
+	}
+}
+
+func TestCutterService_Crop_KeySizeLimit(t *testing.T) {
+	folder, err := ioutil.TempDir("", "cutter-cache")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(folder)
+	cache, err := lru.NewCache(context.Background(), zap.NewNop(), 1024*1024, folder, time.Hour, 0, 0.8)
+	if err != nil {
+		t.Fatalf("NewCache() error = %v", err)
+	}
+	defer cache.Close()
+
+	config := &cfg.CutterConfig{}
+	config.Cutter.Cache.Folder = folder
+	source := &Source{Name: "catalog", Url: "http://images.example.com/"}
+	cs := &CutterService{Logger: zap.NewNop(), Config: config, Cache: cache, Cropper: cropper.NewCropper(zap.NewNop(), config),
+		Sources: map[string]*Source{"catalog": source}, APIKeys: testAPIKeys()}
+
+	// Wide and tall originals are cached, so crops with zero dimension keep their aspect ratio
+	for name, size := range map[string]image.Rectangle{"wide": image.Rect(0, 0, 4000, 10), "tall": image.Rect(0, 0, 10, 2000)} {
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, image.NewGray(size)); err != nil {
+			t.Fatalf("png.Encode() error = %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(folder, name), buf.Bytes(), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		url := cacheKey(source.Url+name+".png", nil)
+		if err := cache.Add(&models.Image{Name: name, Url: url, Size: int64(buf.Len()), Format: "png"}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/crop/{width}/{height}/{url:(?:.+)}", cs.Crop)
+	router.Use(cs.accessLogMiddleware, cs.authMiddleware)
+	tests := []struct {
+		name string
+		key  string
+		url  string
+		want int
+	}{
+		{name: "Width in limit", key: "shop-secret", url: "/crop/1000/0/catalog/wide.png", want: http.StatusOK},
+		{name: "Computed width above limit", key: "shop-secret", url: "/crop/0/10/catalog/wide.png", want: http.StatusForbidden},
+		{name: "Computed height above limit", key: "shop-secret", url: "/crop/10/0/catalog/tall.png", want: http.StatusForbidden},
+		{name: "Computed height in limit", key: "shop-secret", url: "/crop/2/0/catalog/tall.png", want: http.StatusOK},
+		{name: "No size limit", key: "mobile-secret", url: "/crop/0/10/catalog/wide.png", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodHead, tt.url, nil)
+			r.Header.Set("X-Api-Key", tt.key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("Crop() code = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+
+	usage, _ := cs.APIKeys.Usage()
+	for _, u := range usage {
+		if u.Name == "shop" && u.Forbidden != 2 {
+			t.Errorf("Usage() of shop = %+v, want 2 forbidden", u)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package cutter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // requests pass
+	BreakerOpen     = "open"      // requests fail fast until cooldown ends
+	BreakerHalfOpen = "half-open" // one probe request passes
+)
+
+// Breaker stops requests to remote host after threshold consecutive failures
+type Breaker struct {
+	Host      string
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	updated   time.Time // last use, idle breakers are pruned
+	lock      sync.Mutex
+}
+
+type BreakerState struct {
+	Host     string    `json:"host"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at,omitempty"`
+}
+
+// Allow reports whether request to host may be sent
+func (b *Breaker) Allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.updated = time.Now()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		return false // probe request is in flight
+	}
+	return true
+}
+
+// Success closes breaker
+func (b *Breaker) Success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.updated = time.Now()
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+// Failure opens breaker after threshold consecutive failures or if probe request failed
+func (b *Breaker) Failure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.updated = time.Now()
+	b.failures += 1
+	if b.threshold > 0 && (b.state == BreakerHalfOpen || b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Abort releases probe of canceled request, so next request probes host again
+func (b *Breaker) Abort() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+		b.openedAt = time.Now().Add(-b.cooldown)
+	}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	state := BreakerState{Host: b.Host, State: b.state, Failures: b.failures}
+	if b.state != BreakerClosed {
+		state.OpenedAt = b.openedAt
+	}
+	return state
+}
+
+// Breakers keeps circuit breaker for every remote host
+type Breakers struct {
+	threshold int
+	cooldown  time.Duration
+	breakers  map[string]*Breaker
+	pruned    time.Time
+	lock      sync.Mutex
+}
+
+func NewBreakers(threshold int, cooldown time.Duration) *Breakers {
+	return &Breakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[string]*Breaker),
+		pruned:    time.Now(),
+	}
+}
+
+// Get returns breaker of host, creating it if needed. Breakers are not kept if they are disabled
+func (bs *Breakers) Get(host string) *Breaker {
+	if bs.threshold <= 0 {
+		return &Breaker{Host: host, state: BreakerClosed}
+	}
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	bs.prune()
+	breaker, ok := bs.breakers[host]
+	if !ok {
+		breaker = &Breaker{Host: host, threshold: bs.threshold, cooldown: bs.cooldown, state: BreakerClosed, updated: time.Now()}
+		bs.breakers[host] = breaker
+	}
+	return breaker
+}
+
+// prune removes breakers which were not used longer than cooldown, their open state is over anyway.
+// Called with lock held, see HostLimiters.prune
+func (bs *Breakers) prune() {
+	idleTimeout := bs.cooldown
+	if idleTimeout < time.Minute {
+		idleTimeout = time.Minute
+	}
+	if time.Since(bs.pruned) < time.Minute {
+		return
+	}
+	bs.pruned = time.Now()
+	for host, breaker := range bs.breakers {
+		breaker.lock.Lock()
+		idle := time.Since(breaker.updated)
+		breaker.lock.Unlock()
+		if idle > idleTimeout {
+			delete(bs.breakers, host)
+		}
+	}
+}
+
+// States returns states of all breakers sorted by host
+func (bs *Breakers) States() []BreakerState {
+	bs.lock.Lock()
+	breakers := make([]*Breaker, 0, len(bs.breakers))
+	for _, breaker := range bs.breakers {
+		breakers = append(breakers, breaker)
+	}
+	bs.lock.Unlock()
+
+	states := make([]BreakerState, 0, len(breakers))
+	for _, breaker := range breakers {
+		states = append(states, breaker.State())
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Host < states[j].Host
+	})
+	return states
+}
@@ -0,0 +1,142 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreaker_Transitions(t *testing.T) {
+	const cooldown = time.Minute
+	type step struct {
+		action    string // allow, success, failure, abort or cooldown
+		wantAllow bool   // result of allow
+		wantState string // state after action
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{name: "Opens after threshold consecutive failures", threshold: 3, steps: []step{
+			{action: "failure", wantState: BreakerClosed},
+			{action: "failure", wantState: BreakerClosed},
+			{action: "allow", wantAllow: true, wantState: BreakerClosed},
+			{action: "failure", wantState: BreakerOpen},
+			{action: "allow", wantAllow: false, wantState: BreakerOpen},
+		}},
+		{name: "Success resets failures", threshold: 2, steps: []step{
+			{action: "failure", wantState: BreakerClosed},
+			{action: "success", wantState: BreakerClosed},
+			{action: "failure", wantState: BreakerClosed},
+			{action: "allow", wantAllow: true, wantState: BreakerClosed},
+		}},
+		{name: "Probe after cooldown closes breaker", threshold: 1, steps: []step{
+			{action: "failure", wantState: BreakerOpen},
+			{action: "cooldown", wantState: BreakerOpen},
+			{action: "allow", wantAllow: true, wantState: BreakerHalfOpen},
+			{action: "allow", wantAllow: false, wantState: BreakerHalfOpen},
+			{action: "success", wantState: BreakerClosed},
+			{action: "allow", wantAllow: true, wantState: BreakerClosed},
+		}},
+		{name: "Failed probe opens breaker again", threshold: 3, steps: []step{
+			{action: "failure", wantState: BreakerClosed},
+			{action: "failure", wantState: BreakerClosed},
+			{action: "failure", wantState: BreakerOpen},
+			{action: "cooldown", wantState: BreakerOpen},
+			{action: "allow", wantAllow: true, wantState: BreakerHalfOpen},
+			{action: "failure", wantState: BreakerOpen},
+			{action: "allow", wantAllow: false, wantState: BreakerOpen},
+		}},
+		{name: "Aborted probe lets next request probe", threshold: 1, steps: []step{
+			{action: "failure", wantState: BreakerOpen},
+			{action: "cooldown", wantState: BreakerOpen},
+			{action: "allow", wantAllow: true, wantState: BreakerHalfOpen},
+			{action: "abort", wantState: BreakerOpen},
+			{action: "allow", wantAllow: true, wantState: BreakerHalfOpen},
+		}},
+		{name: "Abort does not close open breaker", threshold: 1, steps: []step{
+			{action: "failure", wantState: BreakerOpen},
+			{action: "abort", wantState: BreakerOpen},
+			{action: "allow", wantAllow: false, wantState: BreakerOpen},
+		}},
+		{name: "Disabled breaker never opens", threshold: 0, steps: []step{
+			{action: "failure", wantState: BreakerClosed},
+			{action: "failure", wantState: BreakerClosed},
+			{action: "allow", wantAllow: true, wantState: BreakerClosed},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := NewBreakers(tt.threshold, cooldown).Get("example.com")
+			for i, s := range tt.steps {
+				switch s.action {
+				case "allow":
+					if got := breaker.Allow(); got != s.wantAllow {
+						t.Fatalf("step %v: Allow() = %v, want %v", i, got, s.wantAllow)
+					}
+				case "success":
+					breaker.Success()
+				case "failure":
+					breaker.Failure()
+				case "abort":
+					breaker.Abort()
+				case "cooldown":
+					breaker.lock.Lock()
+					breaker.openedAt = breaker.openedAt.Add(-cooldown)
+					breaker.lock.Unlock()
+				}
+				if got := breaker.State().State; got != s.wantState {
+					t.Fatalf("step %v %v: state = %v, want %v", i, s.action, got, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakers_Prune(t *testing.T) {
+	breakers := NewBreakers(1, time.Minute)
+	idle := breakers.Get("idle.example.com")
+	used := breakers.Get("used.example.com")
+	used.Failure()
+
+	// Time passes
+	idle.updated = idle.updated.Add(-2 * time.Minute)
+	breakers.pruned = breakers.pruned.Add(-2 * time.Minute)
+
+	breakers.Get("new.example.com")
+	states := breakers.States()
+	if len(states) != 2 || states[0].Host != "new.example.com" || states[1].Host != "used.example.com" {
+		t.Fatalf("States() after prune = %+v, want new.example.com and used.example.com", states)
+	}
+	if states[1].State != BreakerOpen {
+		t.Errorf("State() of used breaker = %v, want %v", states[1].State, BreakerOpen)
+	}
+	if breakers.Get("used.example.com") != used {
+		t.Errorf("Get() of used host returns new breaker")
+	}
+
+	disabled := NewBreakers(0, time.Minute)
+	disabled.Get("example.com").Failure()
+	if states := disabled.States(); len(states) != 0 {
+		t.Errorf("States() of disabled breakers = %+v, want none", states)
+	}
+}
+
+func TestCutterService_doWithRetries_BreakerOpen(t *testing.T) {
+	cs := &CutterService{Logger: zap.NewNop(), Config: &cfg.CutterConfig{}, Breakers: NewBreakers(1, time.Minute)}
+	cs.Breakers.Get("example.com").Failure()
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/a.png", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	_, err = cs.doWithRetries(context.Background(), req, &Source{Client: http.DefaultClient})
+	if !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("doWithRetries() error = %v, want ErrBreakerOpen", err)
+	}
+}
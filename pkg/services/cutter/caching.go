@@ -0,0 +1,72 @@
+package cutter
+
+import (
+	"ImageCutter/pkg/cropper"
+	"ImageCutter/pkg/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cropETag returns strong ETag of cropped image. Image name starts with hash of original content,
+// so ETag changes only if original or transform parameters change
+func cropETag(image *models.Image, width int, height int) string {
+	checksum := strings.SplitN(image.Name, ".", 2)[0]
+	hash := sha256.New()
+	hash.Write([]byte(fmt.Sprintf("%v\n%vx%v\n%v", checksum, width, height, cropper.OutputMimeType(image.MimeType))))
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// lastModified returns Last-Modified of original image, or zero time if remote server did not send it
+func lastModified(image *models.Image) time.Time {
+	for name, value := range image.Headers {
+		if http.CanonicalHeaderKey(name) == "Last-Modified" {
+			modified, err := http.ParseTime(value)
+			if err == nil {
+				return modified
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// notModified checks conditional request headers. If-Modified-Since is ignored when If-None-Match is present
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !modified.IsZero() {
+		sinceTime, err := http.ParseTime(since)
+		return err == nil && !modified.Truncate(time.Second).After(sinceTime)
+	}
+	return false
+}
+
+// writeCachingHeaders sets ETag, Last-Modified, Cache-Control and Vary of cropped image.
+// Configured Cache-Control is used if remote server one is not proxied. Image fetched with
+// forwarded client headers, e.g. cookies, belongs to this client, so shared caches must not store it
+func (cs *CutterService) writeCachingHeaders(w http.ResponseWriter, etag string, modified time.Time, source *Source, forwarded http.Header) {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if vary := varyHeaders(source); len(vary) > 0 {
+		w.Header().Add("Vary", strings.Join(vary, ", "))
+	}
+	if len(forwarded) > 0 {
+		w.Header().Set("Cache-Control", "private")
+		return
+	}
+	if w.Header().Get("Cache-Control") == "" && cs.Config.Cutter.Response.CacheControl != "" {
+		w.Header().Set("Cache-Control", cs.Config.Cutter.Response.CacheControl)
+	}
+}
@@ -0,0 +1,120 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"ImageCutter/pkg/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCropETag(t *testing.T) {
+	image := &models.Image{Name: "5d41402abc4b2a76b9719d911017c592", MimeType: "image/png"}
+	etag := cropETag(image, 100, 50)
+	if len(etag) != 34 || etag[0] != '"' || etag[33] != '"' {
+		t.Fatalf("cropETag() = %v is not quoted strong ETag", etag)
+	}
+
+	tests := []struct {
+		name   string
+		image  *models.Image
+		width  int
+		height int
+		same   bool
+	}{
+		{name: "Same image and size", image: &models.Image{Name: image.Name, MimeType: "image/png"}, width: 100, height: 50, same: true},
+		{name: "Same content from another url", image: &models.Image{Name: image.Name, MimeType: "image/png", Url: "other"}, width: 100, height: 50, same: true},
+		{name: "Private copy of same content", image: &models.Image{Name: image.Name + ".123456.tmp", MimeType: "image/png"}, width: 100, height: 50, same: true},
+		{name: "Other width", image: image, width: 101, height: 50, same: false},
+		{name: "Other height", image: image, width: 100, height: 0, same: false},
+		{name: "Other content", image: &models.Image{Name: "7d793037a0760186574b0282f2f435e7", MimeType: "image/png"}, width: 100, height: 50, same: false},
+		{name: "Other output format", image: &models.Image{Name: image.Name, MimeType: "image/webp"}, width: 100, height: 50, same: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cropETag(tt.image, tt.width, tt.height); (got == etag) != tt.same {
+				t.Errorf("cropETag() = %v, ETag of original request %v, want same %v", got, etag, tt.same)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	etag := `"0123456789abcdef0123456789abcdef"`
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		modified time.Time
+		want     bool
+	}{
+		{name: "No conditional headers", modified: modified, want: false},
+		{name: "Matching ETag", headers: map[string]string{"If-None-Match": etag}, want: true},
+		{name: "Matching weak ETag", headers: map[string]string{"If-None-Match": "W/" + etag}, want: true},
+		{name: "ETag in list", headers: map[string]string{"If-None-Match": `"other", ` + etag}, want: true},
+		{name: "Any ETag", headers: map[string]string{"If-None-Match": "*"}, want: true},
+		{name: "Other ETag", headers: map[string]string{"If-None-Match": `"other"`}, want: false},
+		{name: "Unquoted ETag", headers: map[string]string{"If-None-Match": etag[1 : len(etag)-1]}, want: false},
+		{name: "Not modified since", headers: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, modified: modified, want: true},
+		{name: "Modified since", headers: map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, modified: modified, want: false},
+		{name: "Later If-Modified-Since", headers: map[string]string{"If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat)}, modified: modified, want: true},
+		{name: "Unknown Last-Modified", headers: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, want: false},
+		{name: "Broken If-Modified-Since", headers: map[string]string{"If-Modified-Since": "yesterday"}, modified: modified, want: false},
+		{name: "If-None-Match wins over If-Modified-Since", headers: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified.Format(http.TimeFormat)}, modified: modified, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/crop/100/50/images/a.png", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := notModified(r, etag, tt.modified); got != tt.want {
+				t.Errorf("notModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutterService_writeCachingHeaders(t *testing.T) {
+	config := &cfg.CutterConfig{}
+	config.Cutter.Response.CacheControl = "public, max-age=86400"
+	cs := &CutterService{Config: config}
+
+	plain := &Source{}
+	withCookies := &Source{ForwardHeaders: []string{"cookie", "Authorization", "Connection"}}
+	cookies := http.Header{"Cookie": []string{"session=1"}}
+
+	tests := []struct {
+		name         string
+		source       *Source
+		forwarded    http.Header
+		originCache  string
+		cacheControl string
+		vary         string
+	}{
+		{name: "Configured Cache-Control", source: plain, cacheControl: "public, max-age=86400"},
+		{name: "Proxied Cache-Control of remote server", source: plain, originCache: "public, max-age=60", cacheControl: "public, max-age=60"},
+		{name: "Source forwards headers, client sent none", source: withCookies, cacheControl: "public, max-age=86400", vary: "Cookie, Authorization"},
+		{name: "Forwarded headers make response private", source: withCookies, forwarded: cookies, cacheControl: "private", vary: "Cookie, Authorization"},
+		{name: "Forwarded headers override public Cache-Control of remote server", source: withCookies, forwarded: cookies, originCache: "public, max-age=60", cacheControl: "private", vary: "Cookie, Authorization"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if tt.originCache != "" {
+				w.Header().Set("Cache-Control", tt.originCache)
+			}
+			cs.writeCachingHeaders(w, `"etag"`, time.Time{}, tt.source, tt.forwarded)
+			if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("writeCachingHeaders() Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+			if got := w.Header().Get("Vary"); got != tt.vary {
+				t.Errorf("writeCachingHeaders() Vary = %q, want %q", got, tt.vary)
+			}
+			if got := w.Header().Get("ETag"); got != `"etag"` {
+				t.Errorf("writeCachingHeaders() ETag = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"ImageCutter/pkg/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrBreakerOpen is returned when circuit breaker of remote host is open
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// NewOriginClient creates HTTP client for fetching images from remote servers allowed by policy.
+// Addresses are checked after DNS resolution, only hosts in policy.PrivateHosts may resolve
+// to private addresses. Proxy is not used, otherwise policy would check proxy address instead of remote server address
+func NewOriginClient(config cfg.Origin, policy *OriginPolicy) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectTimeout.Duration(),
+		KeepAlive: 30 * time.Second,
+		Control:   policy.Control,
+	}
+	privateDialer := &net.Dialer{
+		Timeout:   config.ConnectTimeout.Duration(),
+		KeepAlive: 30 * time.Second,
+	}
+	dial := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil && policy.allowsPrivate(host) {
+			return privateDialer.DialContext(ctx, network, address)
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+	transport := &http.Transport{
+		DialContext:           dial,
+		TLSHandshakeTimeout:   config.TLSTimeout.Duration(),
+		ResponseHeaderTimeout: config.HeaderTimeout.Duration(),
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout.Duration(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > config.MaxRedirects {
+				return fmt.Errorf("stopped after %v redirects", config.MaxRedirects)
+			}
+			return policy.CheckURL(req.URL)
+		},
+	}
+}
+
+// isTimeout reports whether err is network timeout
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// isRetryable reports whether failed request may be sent again
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false
+		}
+		return !isForbidden(err) && !isTimeout(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// originStatus maps error code of remote server to code of cutter response.
+// Server errors and gateway errors are passed through, other codes, e.g. 401 and 403, mean that
+// cutter can not get image, so they are 502
+func originStatus(code int) int {
+	switch code {
+	case http.StatusNotFound, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return code
+	case http.StatusGone:
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// doWithRetries sends GET request to source with retries and circuit breaker of request host.
+// Trace context is sent to named sources only, raw urls may be any third-party servers
+func (cs *CutterService) doWithRetries(ctx context.Context, req *http.Request, source *Source) (*http.Response, error) {
+	logger := cs.log(ctx)
+	originConfig := cs.Config.Cutter.Origin
+	breaker := cs.Breakers.Get(req.URL.Host)
+
+	for attempt := 0; ; attempt++ {
+		if !breaker.Allow() {
+			return nil, fmt.Errorf("%v: %w", req.URL.Host, ErrBreakerOpen)
+		}
+		spanCtx, span := tracing.StartKind(ctx, "HTTP "+req.Method, trace.SpanKindClient)
+		span.SetAttributes(
+			attribute.String("http.url", req.URL.String()),
+			attribute.Int("http.attempt", attempt+1),
+		)
+		if source.Name != "" {
+			tracing.Inject(spanCtx, req.Header)
+		}
+		resp, err := source.Client.Do(req)
+		if err != nil {
+			tracing.SetError(span, err)
+		} else {
+			span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		}
+		span.End()
+		if err != nil && req.Context().Err() != nil {
+			// Client went away, it is not failure of remote host
+			breaker.Abort()
+			return nil, err
+		}
+		if err != nil || resp.StatusCode >= 500 {
+			breaker.Failure()
+		} else {
+			breaker.Success()
+		}
+		if attempt >= originConfig.Retries || !isRetryable(resp, err) {
+			return resp, err
+		}
+		if err != nil {
+			logger.Sugar().Warnf("Fetching url: %v give error: %v. Retry %v/%v", req.URL, err, attempt+1, originConfig.Retries)
+		} else {
+			logger.Sugar().Warnf("Fetching url: %v return %v code. Retry %v/%v", req.URL, resp.StatusCode, attempt+1, originConfig.Retries)
+			_ = resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff(attempt, originConfig.RetryBackoff.Duration(), originConfig.RetryMaxBackoff.Duration())):
+		}
+	}
+}
+
+// backoff returns exponential delay with jitter: random value in [delay/2, delay]
+func backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	delay := base << uint(attempt)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,223 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"bytes"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestFetcher returns service which fetches raw urls from loopback test servers into temp cache folder
+func newTestFetcher(t *testing.T, origin cfg.Origin) (*CutterService, func()) {
+	folder, err := ioutil.TempDir("", "cutter-fetch")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	origin.AllowPrivate = true
+	origin.Schemes = []string{"http"}
+	if origin.MaxSize == 0 {
+		origin.MaxSize = 1024 * 1024
+	}
+	if origin.Timeout == 0 {
+		origin.Timeout = cfg.Duration(5 * time.Second)
+	}
+	config := &cfg.CutterConfig{}
+	config.Cutter.Origin = origin
+	config.Cutter.Cache.Folder = folder
+	policy := NewOriginPolicy(origin)
+	cs := &CutterService{
+		Logger:    zap.NewNop(),
+		Config:    config,
+		RawSource: &Source{Policy: policy, Client: NewOriginClient(origin, policy)},
+		Breakers:  NewBreakers(origin.BreakerThreshold, time.Minute),
+		Limiters:  NewHostLimiters(origin),
+	}
+	return cs, func() { _ = os.RemoveAll(folder) }
+}
+
+func TestCutterService_fetchImage_OriginErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantCode     int
+		wantAttempts int32
+	}{
+		{name: "Unavailable after all retries", status: http.StatusServiceUnavailable, wantCode: http.StatusServiceUnavailable, wantAttempts: 3},
+		{name: "Bad gateway after all retries", status: http.StatusBadGateway, wantCode: http.StatusBadGateway, wantAttempts: 3},
+		{name: "Gateway timeout after all retries", status: http.StatusGatewayTimeout, wantCode: http.StatusGatewayTimeout, wantAttempts: 3},
+		{name: "Server error is not retried", status: http.StatusInternalServerError, wantCode: http.StatusInternalServerError, wantAttempts: 1},
+		{name: "Other server error", status: http.StatusHTTPVersionNotSupported, wantCode: http.StatusBadGateway, wantAttempts: 1},
+		{name: "Not found", status: http.StatusNotFound, wantCode: http.StatusNotFound, wantAttempts: 1},
+		{name: "Gone", status: http.StatusGone, wantCode: http.StatusNotFound, wantAttempts: 1},
+		{name: "Unauthorized", status: http.StatusUnauthorized, wantCode: http.StatusBadGateway, wantAttempts: 1},
+		{name: "Forbidden", status: http.StatusForbidden, wantCode: http.StatusBadGateway, wantAttempts: 1},
+		{name: "Other client error", status: http.StatusTeapot, wantCode: http.StatusBadGateway, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&attempts, 1)
+				// Error page which starts with image magic bytes must not be decoded
+				w.Header().Set("Content-Type", "image/gif")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte("GIF89a error page"))
+			}))
+			defer origin.Close()
+			cs, cleanup := newTestFetcher(t, cfg.Origin{Retries: 2, RetryBackoff: cfg.Duration(time.Millisecond), RetryMaxBackoff: cfg.Duration(time.Millisecond)})
+			defer cleanup()
+
+			fetched, code, err := cs.fetchImage(context.Background(), origin.URL+"/a.gif", cs.RawSource, nil)
+			if err == nil || fetched != nil {
+				t.Fatalf("fetchImage() = %+v, error = %v, want error", fetched, err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("fetchImage() code = %v, want %v (error %v)", code, tt.wantCode, err)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("fetchImage() made %v requests, want %v", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+// testPNG returns encoded 2x2 PNG image
+func testPNG(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCutterService_fetchImage_MaxSize(t *testing.T) {
+	body := testPNG(t)
+	tests := []struct {
+		name     string
+		maxSize  cfg.ByteSize
+		chunked  bool
+		wantCode int
+	}{
+		{name: "Image in limit", maxSize: cfg.ByteSize(len(body)), wantCode: http.StatusOK},
+		{name: "Content-Length above limit", maxSize: cfg.ByteSize(len(body) - 1), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "Chunked image in limit", maxSize: cfg.ByteSize(len(body)), chunked: true, wantCode: http.StatusOK},
+		{name: "Chunked body above limit", maxSize: cfg.ByteSize(len(body) - 1), chunked: true, wantCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				if !tt.chunked {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				}
+				_, _ = w.Write(body[:10])
+				w.(http.Flusher).Flush()
+				_, _ = w.Write(body[10:])
+			}))
+			defer origin.Close()
+			cs, cleanup := newTestFetcher(t, cfg.Origin{MaxSize: tt.maxSize})
+			defer cleanup()
+
+			fetched, code, err := cs.fetchImage(context.Background(), origin.URL+"/a.png", cs.RawSource, nil)
+			if code != tt.wantCode {
+				t.Fatalf("fetchImage() code = %v, want %v (error %v)", code, tt.wantCode, err)
+			}
+			if code == http.StatusOK && (fetched.Size != int64(len(body)) || fetched.Format != "png") {
+				t.Errorf("fetchImage() = %+v", fetched)
+			}
+			files, _ := ioutil.ReadDir(cs.Config.Cutter.Cache.Folder)
+			if wantFiles := map[bool]int{true: 1, false: 0}[code == http.StatusOK]; len(files) != wantFiles {
+				t.Errorf("cache folder has %v files, want %v", len(files), wantFiles)
+			}
+		})
+	}
+}
+
+func TestCutterService_fetchImage_Redirects(t *testing.T) {
+	body := testPNG(t)
+	// /redirect/n redirects n times before image
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var left int
+		if _, err := fmt.Sscanf(r.URL.Path, "/redirect/%d", &left); err == nil && left > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/redirect/%v", left-1), http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(body)
+	}))
+	defer origin.Close()
+
+	tests := []struct {
+		name      string
+		redirects int
+		wantCode  int
+	}{
+		{name: "Without redirects", redirects: 0, wantCode: http.StatusOK},
+		{name: "Redirects in limit", redirects: 2, wantCode: http.StatusOK},
+		{name: "Redirects above limit", redirects: 3, wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, cleanup := newTestFetcher(t, cfg.Origin{MaxRedirects: 2})
+			defer cleanup()
+			_, code, err := cs.fetchImage(context.Background(), fmt.Sprintf("%v/redirect/%v", origin.URL, tt.redirects), cs.RawSource, nil)
+			if code != tt.wantCode {
+				t.Errorf("fetchImage() code = %v, want %v (error %v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestCutterService_fetchImage_Timeout(t *testing.T) {
+	body := testPNG(t)
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		if r.URL.Path == "/slow-headers" {
+			<-release
+		}
+		_, _ = w.Write(body[:20])
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/slow-body" {
+			<-release
+		}
+		_, _ = w.Write(body[20:])
+	}))
+	defer origin.Close()
+	defer close(release)
+
+	tests := []struct {
+		name   string
+		origin cfg.Origin
+		path   string
+	}{
+		{name: "Request timeout before headers", origin: cfg.Origin{Timeout: cfg.Duration(100 * time.Millisecond)}, path: "/slow-headers"},
+		{name: "Request timeout while reading body", origin: cfg.Origin{Timeout: cfg.Duration(100 * time.Millisecond)}, path: "/slow-body"},
+		{name: "Header timeout", origin: cfg.Origin{HeaderTimeout: cfg.Duration(100 * time.Millisecond)}, path: "/slow-headers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, cleanup := newTestFetcher(t, tt.origin)
+			defer cleanup()
+			start := time.Now()
+			_, code, err := cs.fetchImage(context.Background(), origin.URL+tt.path, cs.RawSource, nil)
+			if code != http.StatusGatewayTimeout {
+				t.Errorf("fetchImage() code = %v, want %v (error %v)", code, http.StatusGatewayTimeout, err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("fetchImage() took %v, timeout is not applied", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsPolicy answers preflight requests and adds CORS headers to responses for allowed origins
+type corsPolicy struct {
+	config        cfg.Cors
+	anyOrigin     bool
+	anyHeader     bool
+	methods       map[string]bool
+	headers       map[string]bool
+	allowMethods  string
+	exposeHeaders string
+	maxAge        string
+}
+
+func newCorsPolicy(config cfg.Cors) *corsPolicy {
+	policy := &corsPolicy{
+		config:        config,
+		methods:       make(map[string]bool),
+		headers:       make(map[string]bool),
+		exposeHeaders: strings.Join(config.ExposeHeaders, ", "),
+		maxAge:        strconv.Itoa(int(config.MaxAge.Duration().Seconds())),
+	}
+	for _, origin := range config.AllowOrigins {
+		if origin == "*" {
+			policy.anyOrigin = true
+		}
+	}
+	methods := make([]string, 0, len(config.AllowMethods))
+	for _, method := range config.AllowMethods {
+		method = strings.ToUpper(method)
+		policy.methods[method] = true
+		methods = append(methods, method)
+	}
+	policy.allowMethods = strings.Join(methods, ", ")
+	for _, header := range config.AllowHeaders {
+		if header == "*" {
+			policy.anyHeader = true
+		}
+		policy.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return policy
+}
+
+// allowsOrigin matches origin with allowed origins, "https://*.example.com" matches subdomains.
+// Wildcard matches only host labels, so it can not swallow scheme, port or path
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	for _, pattern := range p.config.AllowOrigins {
+		if pattern == origin {
+			return true
+		}
+		if i := strings.Index(pattern, "*."); i >= 0 {
+			prefix, suffix := pattern[:i], pattern[i+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:@?#") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsHeaders checks headers of Access-Control-Request-Headers
+func (p *corsPolicy) allowsHeaders(requested string) (string, bool) {
+	for _, header := range strings.Split(requested, ",") {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header != "" && !p.anyHeader && !p.headers[header] {
+			return header, false
+		}
+	}
+	return "", true
+}
+
+// writeOrigin allows origin to read response. Wildcard is not allowed with credentials, so origin is echoed
+func (p *corsPolicy) writeOrigin(w http.ResponseWriter, origin string) {
+	if p.anyOrigin && !p.config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// corsMiddleware wraps router, so preflight OPTIONS requests are answered for every route
+// before method matching, API key and rate checks. Disabled if allowed origins are empty
+func (cs *CutterService) corsMiddleware(next http.Handler) http.Handler {
+	if len(cs.Config.Cutter.Cors.AllowOrigins) == 0 {
+		return next
+	}
+	policy := newCorsPolicy(cs.Config.Cutter.Cors)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers depend on Origin even for "*", requests without Origin get none,
+		// so shared caches must not serve response of one origin to another
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || requestedMethod == "" {
+			// Actual request
+			if policy.allowsOrigin(origin) {
+				policy.writeOrigin(w, origin)
+				if policy.exposeHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", policy.exposeHeaders)
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Preflight request
+		w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+		if !policy.allowsOrigin(origin) {
+			http.Error(w, fmt.Sprintf("Origin %v is not allowed", origin), http.StatusForbidden)
+			return
+		}
+		if !policy.methods[requestedMethod] {
+			http.Error(w, fmt.Sprintf("Method %v is not allowed", requestedMethod), http.StatusForbidden)
+			return
+		}
+		requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+		if header, ok := policy.allowsHeaders(requestedHeaders); !ok {
+			http.Error(w, fmt.Sprintf("Header %v is not allowed", header), http.StatusForbidden)
+			return
+		}
+		policy.writeOrigin(w, origin)
+		w.Header().Set("Access-Control-Allow-Methods", policy.allowMethods)
+		if requestedHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requestedHeaders)
+		}
+		w.Header().Set("Access-Control-Max-Age", policy.maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
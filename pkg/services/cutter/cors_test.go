@@ -0,0 +1,133 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCorsPolicy_allowsOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{name: "Exact origin", allowed: []string{"https://editor.example.com"}, origin: "https://editor.example.com", want: true},
+		{name: "Other scheme", allowed: []string{"https://editor.example.com"}, origin: "http://editor.example.com", want: false},
+		{name: "Other port", allowed: []string{"https://editor.example.com"}, origin: "https://editor.example.com:8443", want: false},
+		{name: "Any origin", allowed: []string{"https://editor.example.com", "*"}, origin: "https://evil.com", want: true},
+		{name: "Subdomain", allowed: []string{"https://*.example.com"}, origin: "https://shop.example.com", want: true},
+		{name: "Nested subdomain", allowed: []string{"https://*.example.com"}, origin: "https://a.shop.example.com", want: true},
+		{name: "Wildcard does not match domain itself", allowed: []string{"https://*.example.com"}, origin: "https://example.com", want: false},
+		{name: "Empty subdomain", allowed: []string{"https://*.example.com"}, origin: "https://.example.com", want: false},
+		{name: "Domain with same suffix", allowed: []string{"https://*.example.com"}, origin: "https://evilexample.com", want: false},
+		{name: "Domain with allowed prefix", allowed: []string{"https://*.example.com"}, origin: "https://shop.example.com.evil.com", want: false},
+		{name: "Wildcard with other scheme", allowed: []string{"https://*.example.com"}, origin: "http://shop.example.com", want: false},
+		{name: "Wildcard does not match path", allowed: []string{"https://*.example.com"}, origin: "https://evil.com/.example.com", want: false},
+		{name: "Wildcard does not match credentials", allowed: []string{"https://*.example.com"}, origin: "https://user@evil.com:.example.com", want: false},
+		{name: "Wildcard with port", allowed: []string{"https://*.example.com:8443"}, origin: "https://shop.example.com:8443", want: true},
+		{name: "Wildcard with other port", allowed: []string{"https://*.example.com:8443"}, origin: "https://shop.example.com", want: false},
+		{name: "Null origin", allowed: []string{"https://*.example.com"}, origin: "null", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newCorsPolicy(cfg.Cors{AllowOrigins: tt.allowed})
+			if got := policy.allowsOrigin(tt.origin); got != tt.want {
+				t.Errorf("allowsOrigin(%v) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutterService_corsMiddleware(t *testing.T) {
+	config := &cfg.CutterConfig{}
+	config.Cutter.Cors = cfg.Cors{
+		AllowOrigins:  []string{"https://*.example.com"},
+		AllowMethods:  []string{"get", "head"},
+		AllowHeaders:  []string{"X-Api-Key"},
+		ExposeHeaders: []string{"ETag"},
+		MaxAge:        cfg.Duration(time.Hour),
+	}
+	cs := &CutterService{Config: config}
+	handler := cs.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name        string
+		method      string
+		headers     map[string]string
+		wantCode    int
+		allowOrigin string
+		preflight   bool // preflight response varies by requested method and headers too
+	}{
+		{name: "Request without origin", method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "Allowed origin", method: http.MethodGet, headers: map[string]string{"Origin": "https://shop.example.com"}, wantCode: http.StatusOK, allowOrigin: "https://shop.example.com"},
+		{name: "Not allowed origin is served without CORS headers", method: http.MethodGet, headers: map[string]string{"Origin": "https://evil.com"}, wantCode: http.StatusOK},
+		{name: "Preflight", method: http.MethodOptions, headers: map[string]string{"Origin": "https://shop.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "x-api-key"}, wantCode: http.StatusNoContent, allowOrigin: "https://shop.example.com", preflight: true},
+		{name: "Preflight of not allowed origin", method: http.MethodOptions, headers: map[string]string{"Origin": "https://evil.com", "Access-Control-Request-Method": "GET"}, wantCode: http.StatusForbidden, preflight: true},
+		{name: "Preflight of not allowed method", method: http.MethodOptions, headers: map[string]string{"Origin": "https://shop.example.com", "Access-Control-Request-Method": "POST"}, wantCode: http.StatusForbidden, preflight: true},
+		{name: "Preflight of not allowed header", method: http.MethodOptions, headers: map[string]string{"Origin": "https://shop.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Other"}, wantCode: http.StatusForbidden, preflight: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/crop/100/100/catalog/a.jpg", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("corsMiddleware() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("corsMiddleware() Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			wantVary := []string{"Origin"}
+			if tt.preflight {
+				wantVary = append(wantVary, "Access-Control-Request-Method, Access-Control-Request-Headers")
+			}
+			if got := w.Header()["Vary"]; !reflect.DeepEqual(got, wantVary) {
+				t.Errorf("corsMiddleware() Vary = %q, want %q", got, wantVary)
+			}
+			if tt.wantCode == http.StatusNoContent && (w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD" || w.Header().Get("Access-Control-Max-Age") != "3600") {
+				t.Errorf("corsMiddleware() preflight headers = %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestCutterService_corsMiddleware_AnyOrigin(t *testing.T) {
+	config := &cfg.CutterConfig{}
+	config.Cutter.Cors = cfg.Cors{AllowOrigins: []string{"*"}, AllowMethods: []string{"GET"}}
+	cs := &CutterService{Config: config}
+	handler := cs.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name        string
+		origin      string
+		allowOrigin string
+	}{
+		{name: "Request without origin", origin: "", allowOrigin: ""},
+		{name: "Request with origin", origin: "https://shop.example.com", allowOrigin: "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/crop/100/100/catalog/a.jpg", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("corsMiddleware() Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			// Shared cache must not serve response without CORS headers to cross-origin request
+			if got := w.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("corsMiddleware() Vary = %q, want %q", got, "Origin")
+			}
+		})
+	}
+}
@@ -5,18 +5,25 @@ import (
 	"ImageCutter/pkg/cropper"
 	"ImageCutter/pkg/lru"
 	"ImageCutter/pkg/models"
-	"crypto/md5"
+	"ImageCutter/pkg/tracing"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
-	urllib "net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 
@@ -25,6 +32,19 @@ type CutterService struct {
 	Config *cfg.CutterConfig
 	Cropper *cropper.Cropper
 	Cache *lru.Cache
+	RawSource *Source
+	Sources map[string]*Source
+	Breakers *Breakers
+	Limiters *HostLimiters
+	Crops *CropLimiter
+	Clients *ClientLimiters
+	CacheStats *lru.Stats
+	Webhook *lru.Webhook
+	Metrics *Metrics
+	Tracer *tracing.Tracer // nil if tracing is disabled
+	APIKeys *APIKeys // nil if API keys are not required
+	Server *http.Server
+	draining int32 // set by Shutdown, fails readiness check
 }
 
 func NewCutterService(logger *zap.Logger, config *cfg.CutterConfig) (*CutterService, error) {
@@ -32,69 +52,165 @@ func NewCutterService(logger *zap.Logger, config *cfg.CutterConfig) (*CutterServ
 
 	logger.Sugar().Infof("Init Cache instance with parameters:\nCACHESIZE=%v\nCACHECLEAN=%v\nCACHEFOLDER=%v\n", config.Cutter.Cache.Size, config.Cutter.Cache.CleanInterval, config.Cutter.Cache.Folder)
 
-	cache, err := lru.NewCache(logger, config.Cutter.Cache.Size, config.Cutter.Cache.Folder, config.Cutter.Cache.CleanInterval)
+	cacheConfig := config.Cutter.Cache
+	cache, err := lru.NewCache(context.Background(), logger, int64(cacheConfig.Size), cacheConfig.Folder, cacheConfig.CleanInterval.Duration(), cacheConfig.CleanJitter.Duration(), cacheConfig.Watermark)
 	if err != nil {
 		logger.Sugar().Errorf("Creating instance of Cache give error: %v", err)
 		return nil, err
 	}
 
-	return &CutterService{
+	// Cache event consumers
+	stats := &lru.Stats{}
+	cache.Subscribe(lru.LogListener(logger))
+	cache.Subscribe(stats.Listen)
+	crops := NewCropLimiter(config.Cutter.Limits)
+	metrics := NewMetrics(cache, crops)
+	cache.Subscribe(metrics.CacheListener)
+	var webhook *lru.Webhook
+	if webhookConfig := config.Cutter.Cache.Webhook; webhookConfig.Url != "" {
+		logger.Sugar().Infof("Cache events will be sent to webhook: %v", webhookConfig.Url)
+		webhook = lru.NewWebhook(logger, webhookConfig.Url, webhookConfig.Timeout.Duration(), webhookConfig.Queue)
+		cache.Subscribe(webhook.Listen)
+	}
+
+	policy := NewOriginPolicy(config.Cutter.Origin)
+	tracer := NewTracer(logger, config.Cutter.Tracing)
+
+	cs := &CutterService{
 		Logger: logger,
 		Config: config,
 		Cropper: cp,
 		Cache: cache,
-	}, nil
+		RawSource: &Source{ForwardHeaders: config.Cutter.Origin.ForwardHeaders, ForwardHosts: config.Cutter.Origin.ForwardHosts, Policy: policy, Client: NewOriginClient(config.Cutter.Origin, policy)},
+		Sources: NewSources(config),
+		Breakers: NewBreakers(config.Cutter.Origin.BreakerThreshold, config.Cutter.Origin.BreakerCooldown.Duration()),
+		Limiters: NewHostLimiters(config.Cutter.Origin),
+		Crops: crops,
+		Clients: NewClientLimiters(config.Cutter.Limits),
+		CacheStats: stats,
+		Webhook: webhook,
+		Metrics: metrics,
+		Tracer: tracer,
+		APIKeys: NewAPIKeys(config.Cutter.Auth),
+	}
+	// Server is created before Start, so Shutdown never races with it
+	cs.Server = cs.newServer()
+	return cs, nil
 }
 
 
+// Close stops cache cleaner and cache event consumers
+func (cs *CutterService) Close() {
+	cs.Cache.Close()
+	if cs.Webhook != nil {
+		cs.Webhook.Close()
+	}
+	if cs.Tracer != nil {
+		cs.Tracer.Close()
+	}
+}
 
-func (cs *CutterService) Start (){
+// newServer creates HTTP server with cutter routes
+func (cs *CutterService) newServer() *http.Server {
 	router := mux.NewRouter()
 
-	router.HandleFunc("/crop/{width}/{height}/{url:(?:.+)}", cs.Crop)
+	router.Handle("/crop/{width}/{height}/{url:(?:.+)}", cs.signatureMiddleware(http.HandlerFunc(cs.Crop)))
 	router.HandleFunc("/cache/{url:(?:.+)}", cs.CheckCache)
+	// Admin routes can dump and replace cache, so they exist only with admin credential
+	if cs.Config.Cutter.Admin.Token != "" {
+		admin := router.PathPrefix("/admin").Subrouter()
+		admin.Use(cs.adminMiddleware)
+		admin.HandleFunc("/cache/stats", cs.CacheStatsHandler).Methods(http.MethodGet)
+		admin.HandleFunc("/cache/clean", cs.CacheCleanHandler).Methods(http.MethodPost)
+		admin.HandleFunc("/cache/snapshot", cs.CacheExportHandler).Methods(http.MethodGet)
+		admin.HandleFunc("/cache/snapshot", cs.CacheImportHandler).Methods(http.MethodPost)
+		admin.HandleFunc("/origins/breakers", cs.BreakersHandler).Methods(http.MethodGet)
+		admin.HandleFunc("/keys", cs.APIKeysHandler).Methods(http.MethodGet)
+	} else {
+		cs.Logger.Warn("Admin routes are disabled, set Cutter.Admin.token to enable them")
+	}
+	router.HandleFunc("/healthz", cs.HealthHandler).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", cs.ReadyHandler).Methods(http.MethodGet)
+	router.Handle("/metrics", cs.Metrics.Handler()).Methods(http.MethodGet)
+	router.Use(cs.accessLogMiddleware, cs.tracingMiddleware, cs.metricsMiddleware, cs.throttleMiddleware, cs.authMiddleware)
 
+	serverConfig := cs.Config.Cutter.Server
+	address := fmt.Sprintf(":%v", cs.Config.Cutter.Port)
+	return &http.Server{
+		Addr: address,
+		Handler: cs.corsMiddleware(router),
+		ReadTimeout: serverConfig.ReadTimeout.Duration(),
+		WriteTimeout: serverConfig.WriteTimeout.Duration(),
+		IdleTimeout: serverConfig.IdleTimeout.Duration(),
+	}
+}
 
-	http.Handle("/", router)
+// Start serves HTTP requests until Shutdown is called
+func (cs *CutterService) Start () error{
+	cs.Logger.Sugar().Infof("Start cutter service at address: %v", cs.Server.Addr)
+	err := cs.Server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	cs.Logger.Sugar().Errorf("HTTP Listener give error: %v", err)
+	return err
+}
 
-	address := fmt.Sprintf(":%v", cs.Config.Cutter.Port)
-	cs.Logger.Sugar().Infof("Start cutter service at address: %v", address)
-	err := http.ListenAndServe(address, nil)
-	cs.Logger.Sugar().Fatalf("HTTP Listener give error: %v", err)
+// Shutdown fails readiness check and waits drain delay, so load balancer stops sending new requests.
+// Then it stops accepting requests, waits for in-flight requests until ctx is done
+// and closes cache, so cache index is saved
+func (cs *CutterService) Shutdown(ctx context.Context) error {
+	defer cs.Close()
+	atomic.StoreInt32(&cs.draining, 1)
+	if delay := cs.Config.Cutter.Server.DrainDelay.Duration(); delay > 0 {
+		cs.Logger.Sugar().Infof("Cutter service is not ready, waiting %v before closing listener...", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	}
+	cs.Logger.Info("Shutting down cutter service, waiting for in-flight requests...")
+	err := cs.Server.Shutdown(ctx)
+	if err != nil {
+		cs.Logger.Sugar().Errorf("HTTP server shutdown give error: %v", err)
+		return err
+	}
+	cs.Logger.Info("All requests are finished")
+	return nil
 }
 
 func (cs *CutterService) CheckCache(w http.ResponseWriter, r *http.Request) {
+	logger := cs.log(r.Context())
 
-	cs.Logger.Info("Try check image in cache...")
+	logger.Info("Try check image in cache...")
 	args := mux.Vars(r)
 
-	url := args["url"]
-	u, _ := urllib.Parse(url)
-	if u.Scheme == ""{
-		mess := fmt.Sprintf("Remote image url is incorrect: %v. Protocol is missed(require http:// or https://)", url)
-		cs.Logger.Error(mess)
-		http.Error(w, mess, 400)
+	url, source, code, err := cs.resolve(args["url"])
+	if err != nil {
+		logger.Error(err.Error())
+		http.Error(w, err.Error(), code)
 		return
 	}
-	// Url scheme must contains two slash not one
-	if u.Host == ""{
-		url = fmt.Sprintf("%v:/%v", u.Scheme, u.Path)
-	}
 
-	// Try get from cache
-	_, err := cs.Cache.GetImageByUrl(url)
-	if err != nil{
-		cs.Logger.Sugar().Infof("Image with url: %v not in cache", url)
+	// Existence check is not crop lookup, so it is not counted as cache hit or miss.
+	// Key is same as in Crop, images fetched with client headers are found for these headers only
+	if !cs.Cache.Contains(cacheKey(url, forwardedHeaders(r, source, url))) {
+		logger.Sugar().Infof("Image with url: %v not in cache", url)
 		http.Error(w, fmt.Sprintf("Image with url: %v not in cache :(", url), 404)
 	} else {
-		cs.Logger.Sugar().Infof("Image with url: %v in cache", url)
+		logger.Sugar().Infof("Image with url: %v in cache", url)
 		http.Error(w, fmt.Sprintf("Image with url: %v in cache :)", url), 200)
 	}
 
 }
 
 func (cs *CutterService) Crop(w http.ResponseWriter, r *http.Request) {
-	cs.Logger.Info("Try crop image...")
+	ctx, span := tracing.Start(r.Context(), "cutter.Crop")
+	defer span.End()
+	logger := cs.log(ctx)
+	logger.Info("Try crop image...")
 	args := mux.Vars(r)
 
 	if args["url"] == ""{
@@ -113,163 +229,348 @@ func (cs *CutterService) Crop(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url := args["url"]
-	u, _ := urllib.Parse(url)
-	if u.Scheme == ""{
-		mess := fmt.Sprintf("Remote image url is incorrect: %v. Protocol is missed(require http:// or https://)", url)
-		cs.Logger.Error(mess)
-		http.Error(w, mess, 400)
+	url, source, code, err := cs.resolve(args["url"])
+	if err != nil {
+		logger.Error(err.Error())
+		http.Error(w, err.Error(), code)
 		return
 	}
-	// Url scheme must contains two slash not one
-	if u.Host == ""{
-		url = fmt.Sprintf("%v:/%v", u.Scheme, u.Path)
-	}
 
 	width, err := strconv.Atoi(args["width"])
 	if err != nil {
 		mess := fmt.Sprintf("Cannot convert width to int from string: %v", err)
-		cs.Logger.Error(mess)
+		logger.Error(mess)
 		http.Error(w, mess, 500)
 		return
 	}
 	height, err := strconv.Atoi(args["height"])
 	if err != nil {
 		mess := fmt.Sprintf("Cannot convert height to int from string: %v", err)
-		cs.Logger.Error(mess)
+		logger.Error(mess)
 		http.Error(w, mess, 500)
 		return
 	}
 	if width == 0 && height == 0{
 		mess := fmt.Sprintf("Both width and height are zero!")
-		cs.Logger.Error(mess)
+		logger.Error(mess)
 		http.Error(w, mess, 400)
 		return
 	}
+	span.SetAttributes(
+		attribute.Int("crop.width", width),
+		attribute.Int("crop.height", height),
+		attribute.String("image.url", url),
+	)
+
+	// Check size before fetching image
+	if code, err := cs.Cropper.CheckSize(width, height); err != nil {
+		logger.Warn(err.Error())
+		http.Error(w, err.Error(), code)
+		return
+	}
 
-	// Try get from cache
-	cacheImage, err := cs.Cache.GetImageByUrl(url)
+	// Try get from cache. Images fetched with client headers are cached for these headers only
+	headers := forwardedHeaders(r, source, url)
+	cacheImage, err := cs.Cache.GetImageByUrlContext(ctx, cacheKey(url, headers))
+	info := requestInfoFrom(ctx)
 
 	// If image not in cache
+	span.SetAttributes(attribute.Bool("cache.hit", err == nil))
 	if err != nil {
+		info.Cache = "miss"
+		info.Origin = originHost(url)
 		// Get image from remote server
-		var code int
-		cacheImage, code, err = cs.FetchImage(url)
+		fetchStarted := time.Now()
+		cacheImage, code, err = cs.FetchImage(ctx, url, source, headers)
+		cs.Metrics.ObserveFetch(source, url, fetchStarted, code, err)
 		if err != nil {
 			mess := fmt.Sprintf("Fetching url: %v give error: %v", url, err)
-			cs.Logger.Error(mess)
+			logger.Error(mess)
+			if limited, ok := rateLimited(err); ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+			}
 			http.Error(w, mess, code)
 			return
 		}
 
-		cs.Logger.Sugar().Infof("Successfully fetched new image: %v", cacheImage.Name)
+		logger.Sugar().Infof("Successfully fetched new image: %v", cacheImage.Name)
 
-		// Add new image to cache
-		err := cs.Cache.Add(cacheImage)
-		if err != nil {
-			cs.Logger.Sugar().Warnf("Cannot add image: %v to cache. Reason: %v",cacheImage.Name, err)
+		if source.NoCache {
+			// Original is stored in private file of this request, remove it after cropping
+			defer func(image *models.Image) {
+				if err := os.Remove(filepath.Join(cs.Config.Cutter.Cache.Folder, image.Name)); err != nil {
+					logger.Sugar().Errorf("Removing not cached image %v give error: %v", image.Name, err)
+				}
+			}(cacheImage)
 		} else {
-			cs.Logger.Sugar().Infof("Image %v now in cache!", cacheImage.Url)
+			// Add new image to cache
+			err := cs.Cache.AddContext(ctx, cacheImage)
+			if err != nil {
+				logger.Sugar().Warnf("Cannot add image: %v to cache. Reason: %v",cacheImage.Name, err)
+			} else {
+				logger.Sugar().Infof("Image %v now in cache!", cacheImage.Url)
+			}
 		}
 
 	} else {
-		cs.Logger.Sugar().Infof("Take image %v from cache", cacheImage.Url)
+		info.Cache = "hit"
+		logger.Sugar().Infof("Take image %v from cache", cacheImage.Url)
 	}
 
 	cacheImage.FetchCount += 1 // Increment fetch count
 
-	croppedImage, err := cs.Cropper.Crop(width, height, cacheImage)
+	// Cropped size depends on original when width or height is zero, check it before answering without crop
+	outWidth, outHeight, code, err := cs.Cropper.Check(width, height, cacheImage)
+	if err != nil {
+		logger.Warn(err.Error())
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if info.key != nil {
+		if reason, ok := info.key.allowsSize(outWidth, outHeight); !ok {
+			info.key.count(cfg.OperationCrop, keyForbidden)
+			mess := fmt.Sprintf("API key %v: cropped image is %vx%v, %v", info.key.Name, outWidth, outHeight, reason)
+			logger.Warn(mess)
+			http.Error(w, mess, http.StatusForbidden)
+			return
+		}
+	}
+
+	// Client already has this thumbnail
+	etag := cropETag(cacheImage, width, height)
+	modified := lastModified(cacheImage)
+	if notModified(r, etag, modified) {
+		cs.proxyHeaders(w, cacheImage)
+		cs.writeCachingHeaders(w, etag, modified, source, headers)
+		w.WriteHeader(304)
+		return
+	}
+	// HEAD does not need cropped image
+	if r.Method == http.MethodHead {
+		cs.proxyHeaders(w, cacheImage)
+		cs.writeCachingHeaders(w, etag, modified, source, headers)
+		w.Header().Set("Content-Type", cropper.OutputMimeType(cacheImage.MimeType))
+		return
+	}
+
+	// Wait for free crop slot, CPU is shared by all crops
+	release, err := cs.Crops.Acquire(ctx)
+	if err != nil {
+		mess := fmt.Sprintf("Cropping image is postponed: %v", err)
+		logger.Warn(mess)
+		if limited, ok := rateLimited(err); ok {
+			cs.Metrics.Throttled.WithLabelValues("crop").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		}
+		http.Error(w, mess, 503)
+		return
+	}
+	cropStarted := time.Now()
+	croppedImage, code, err := cs.Cropper.Crop(ctx, width, height, cacheImage)
+	release()
+	cs.Metrics.ObserveCrop(cacheImage.Format, width, height, cropStarted)
 	if err != nil {
 		mess := fmt.Sprintf("Cropping image give error: %v", err)
-		cs.Logger.Error(mess)
-		http.Error(w, mess, 500)
+		logger.Error(mess)
+		http.Error(w, mess, code)
 		return
 	}
 
+	cs.proxyHeaders(w, cacheImage)
+	cs.writeCachingHeaders(w, etag, modified, source, headers)
+	w.Header().Set("Content-Type", cropper.OutputMimeType(cacheImage.MimeType))
+	w.Header().Set("Content-Length", strconv.Itoa(len(croppedImage)))
 	if _, err := w.Write(croppedImage); err != nil {
-		cs.Logger.Sugar().Errorf("Unable to write cropped image to writer: %v", err)
+		logger.Sugar().Errorf("Unable to write cropped image to writer: %v", err)
 	}
 }
 
 
-func (cs *CutterService) FetchImage(url string) (*models.Image, int, error) {
+// FetchImage downloads image from url of source to cache folder.
+// Forwarded client headers are sent to source and become part of image cache key
+func (cs *CutterService) FetchImage(ctx context.Context, url string, source *Source, forwarded http.Header) (*models.Image, int, error) {
+	ctx, span := tracing.Start(ctx, "cutter.FetchImage")
+	defer span.End()
+	span.SetAttributes(attribute.String("image.url", url))
+	image, code, err := cs.fetchImage(ctx, url, source, forwarded)
+	span.SetAttributes(attribute.Int("http.status_code", code))
+	tracing.SetError(span, err)
+	if image != nil {
+		span.SetAttributes(attribute.Int64("image.size", image.Size))
+	}
+	return image, code, err
+}
 
-	resp, err := http.Get(url)
+func (cs *CutterService) fetchImage(ctx context.Context, url string, source *Source, forwarded http.Header) (*models.Image, int, error) {
+	logger := cs.log(ctx)
+	if source.Root != "" {
+		return cs.fetchFile(ctx, url, source)
+	}
 
-	// If server does not exist
+	originConfig := cs.Config.Cutter.Origin
+	// Request is canceled with client request, so retries and limiter waits stop too
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		cs.Logger.Sugar().Errorf("Fetching url: %v give error: %v", url, err)
-		return nil, 503, err
+		logger.Sugar().Errorf("Creating request for url: %v give error: %v", url, err)
+		return nil, 400, err
 	}
-	// If server return 500 code
-	if resp.StatusCode == 500{
-		mess := fmt.Sprintf("Remote server error return 500 code for url: %v", url)
-		cs.Logger.Info(mess)
-		return nil, 500, errors.New(mess)
+	req.Header.Set("User-Agent", originConfig.UserAgent)
+	for key, values := range forwarded {
+		req.Header[key] = values
 	}
-
-	// If file does not exists
-	if resp.StatusCode == 404{
-		mess := fmt.Sprintf("File not found on url: %v", url)
-		cs.Logger.Info(mess)
-		return nil, 404, errors.New(mess)
+	if id := requestInfoFrom(ctx).ID; id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	// Static source headers cannot be overridden by client
+	for key, value := range source.Headers {
+		req.Header.Set(key, value)
 	}
 
-	// If file mime type not image
-	contentType := resp.Header.Get("Content-Type")
-	if !strings.Contains(contentType, "image"){
-		mess := fmt.Sprintf("Fetching file is not image: %v", contentType)
-		cs.Logger.Warn(mess)
-		return nil, 422, errors.New(mess)
+	// If remote server is not allowed
+	if err := source.Policy.CheckURL(req.URL); err != nil {
+		logger.Sugar().Warnf("Fetching url: %v is forbidden: %v", url, err)
+		return nil, 403, err
 	}
 
-	// Generate file name as hash of url
-	extension := strings.ReplaceAll(contentType, "image/", "")
-	imageName := fmt.Sprintf("%x.%v", md5.Sum([]byte(url)), extension)
+	// Wait for rate and concurrency limits of remote host, slot is held until image is stored
+	release, err := cs.Limiters.Get(req.URL.Host).Acquire(req.Context(), cs.Limiters.QueueTimeout)
+	if err != nil {
+		logger.Sugar().Warnf("Fetching url: %v is limited: %v", url, err)
+		return nil, 503, err
+	}
+	defer release()
 
-	imagePath := filepath.Join(cs.Config.Cutter.Cache.Folder, imageName)
+	resp, err := cs.doWithRetries(ctx, req, source)
 
-	imageFile, err := os.Create(imagePath)
+	// If server does not exist
 	if err != nil {
-		cs.Logger.Sugar().Errorf("Creating file for image give error: %v", err)
-		return nil, 500, err
+		logger.Sugar().Errorf("Fetching url: %v give error: %v", url, err)
+		if errors.Is(err, ErrBreakerOpen) {
+			return nil, 503, err
+		}
+		if isForbidden(err) {
+			return nil, 403, err
+		}
+		if isTimeout(err) {
+			return nil, 504, err
+		}
+		return nil, 503, err
 	}
-
-	// Close resp and file
 	defer func(){
 		err := resp.Body.Close()
 		if err != nil {
-			cs.Logger.Sugar().Errorf("Response body closing give error: %v", err)
-		}
-		err = imageFile.Close()
-		if err != nil {
-			cs.Logger.Sugar().Errorf("Image file closing give error: %v", err)
+			logger.Sugar().Errorf("Response body closing give error: %v", err)
 		}
 	}()
 
-	_, err = io.Copy(imageFile, resp.Body)
+	// If file does not exists
+	if resp.StatusCode == 404{
+		mess := fmt.Sprintf("File not found on url: %v", url)
+		logger.Info(mess)
+		return nil, 404, errors.New(mess)
+	}
+
+	// If server return error code, also after all retries, its body is error page and not image
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		mess := fmt.Sprintf("Remote server error return %v code for url: %v", resp.StatusCode, url)
+		logger.Info(mess)
+		return nil, originStatus(resp.StatusCode), errors.New(mess)
+	}
+
+	// If image is bigger than allowed
+	maxSize := int64(originConfig.MaxSize)
+	if resp.ContentLength > maxSize {
+		mess := fmt.Sprintf("Remote image size %v bytes is higher than allowed %v", resp.ContentLength, originConfig.MaxSize)
+		logger.Warn(mess)
+		return nil, 413, errors.New(mess)
+	}
+
+	// If file is not image or its content does not match Content-Type
+	format, body, code, err := cs.sniffImage(ctx, resp.Body, url, resp.Header.Get("Content-Type"))
 	if err != nil {
-		cs.Logger.Sugar().Errorf("Copying image to file give error: %v", err)
-		return nil, 500, err
+		return nil, code, err
 	}
-	imageStat, err := imageFile.Stat()
+
+	imageName, tempFile, size, code, err := cs.storeImage(ctx, body, url, maxSize, source.NoCache)
 	if err != nil {
-		cs.Logger.Sugar().Errorf("Cannot get size of image, error: %v", err)
-		return nil, 500, err
+		return nil, code, err
 	}
 
+	// Repeated header is same as one header with comma separated values (RFC 7230), Set-Cookie is never proxied
 	headers := make(map[string]string)
 	for key, value := range resp.Header{
-		headers[key] = strings.Join(value, ";")
+		headers[key] = strings.Join(value, ", ")
 	}
 
 	return &models.Image{
 		Name: imageName,
-		Url:url,
+		Url: cacheKey(url, forwarded),
 		Headers: headers,
 		FetchCount: 0,
-		Size: imageStat.Size(),
-		MimeType: contentType,
+		Size: size,
+		MimeType: models.MimeTypeOf(format),
+		Format: format,
+		TempFile: tempFile,
 	}, 200, nil
 
-}
\ No newline at end of file
+}
+
+// storeImage downloads image from r to temp file of cache folder.
+// File name is hash of content, so same image from different urls is stored once.
+// Cached image is left in temp file and cache moves it under its lock, otherwise eviction of
+// same file for another url could remove it right after moving. Private images of nocache sources
+// are moved to unique "{hash}.{random}.tmp" file, so removing it after crop never races with other requests.
+// Returns file name, temp file (empty for private image) and size with http code
+func (cs *CutterService) storeImage(ctx context.Context, r io.Reader, url string, maxSize int64, private bool) (string, string, int64, int, error) {
+	logger := cs.log(ctx)
+	tempFile, err := ioutil.TempFile(cs.Config.Cutter.Cache.Folder, "fetch-*.tmp")
+	if err != nil {
+		logger.Sugar().Errorf("Creating file for image give error: %v", err)
+		return "", "", 0, 500, err
+	}
+
+	// Remove temp file if it was not renamed or passed to cache
+	keep := false
+	defer func(){
+		_ = tempFile.Close()
+		if _, err := os.Stat(tempFile.Name()); err == nil && !keep {
+			_ = os.Remove(tempFile.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), io.LimitReader(r, maxSize+1))
+	if err != nil {
+		logger.Sugar().Errorf("Copying image to file give error: %v", err)
+		if isTimeout(err) {
+			return "", "", 0, 504, err
+		}
+		return "", "", 0, 500, err
+	}
+	// Origin sent more than allowed without declaring it in Content-Length
+	if size > maxSize {
+		mess := fmt.Sprintf("Remote image from url: %v is bigger than allowed %v bytes", url, maxSize)
+		logger.Warn(mess)
+		return "", "", 0, 502, errors.New(mess)
+	}
+	err = tempFile.Close()
+	if err != nil {
+		logger.Sugar().Errorf("Image file closing give error: %v", err)
+		return "", "", 0, 500, err
+	}
+
+	imageName := hex.EncodeToString(hash.Sum(nil))
+	if !private {
+		keep = true
+		return imageName, tempFile.Name(), size, 200, nil
+	}
+	imageName += "." + strings.TrimPrefix(filepath.Base(tempFile.Name()), "fetch-")
+	imagePath := filepath.Join(cs.Config.Cutter.Cache.Folder, imageName)
+	err = os.Rename(tempFile.Name(), imagePath)
+	if err != nil {
+		logger.Sugar().Errorf("Moving image to %v give error: %v", imagePath, err)
+		return "", "", 0, 500, err
+	}
+
+	return imageName, "", size, 200, nil
+}
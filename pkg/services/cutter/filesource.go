@@ -0,0 +1,94 @@
+package cutter
+
+import (
+	"ImageCutter/pkg/models"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fetchFile copies image from root folder of file source to cache folder
+func (cs *CutterService) fetchFile(ctx context.Context, url string, source *Source) (*models.Image, int, error) {
+	logger := cs.log(ctx)
+	imagePath, err := source.localPath(strings.TrimPrefix(url, source.Url))
+	if err != nil {
+		logger.Sugar().Warnf("Fetching url: %v is forbidden: %v", url, err)
+		return nil, 403, err
+	}
+
+	file, err := os.Open(imagePath)
+	if os.IsNotExist(err) {
+		mess := fmt.Sprintf("File not found on url: %v", url)
+		logger.Info(mess)
+		return nil, 404, errors.New(mess)
+	}
+	if err != nil {
+		logger.Sugar().Errorf("Opening file: %v give error: %v", imagePath, err)
+		return nil, 500, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		logger.Sugar().Errorf("Cannot get size of file: %v, error: %v", imagePath, err)
+		return nil, 500, err
+	}
+	if stat.IsDir() {
+		mess := fmt.Sprintf("File not found on url: %v", url)
+		logger.Info(mess)
+		return nil, 404, errors.New(mess)
+	}
+	maxSize := int64(cs.Config.Cutter.Origin.MaxSize)
+	if stat.Size() > maxSize {
+		mess := fmt.Sprintf("Image size %v bytes is higher than allowed %v", stat.Size(), cs.Config.Cutter.Origin.MaxSize)
+		logger.Warn(mess)
+		return nil, 413, errors.New(mess)
+	}
+
+	// If file is not image
+	format, body, code, err := cs.sniffImage(ctx, file, url, "")
+	if err != nil {
+		return nil, code, err
+	}
+
+	imageName, tempFile, size, code, err := cs.storeImage(ctx, body, url, maxSize, source.NoCache)
+	if err != nil {
+		return nil, code, err
+	}
+
+	return &models.Image{
+		Name: imageName,
+		Url:  url,
+		Headers: map[string]string{
+			"Content-Type":  models.MimeTypeOf(format),
+			"Last-Modified": stat.ModTime().UTC().Format(http.TimeFormat),
+		},
+		FetchCount: 0,
+		Size:       size,
+		MimeType:   models.MimeTypeOf(format),
+		Format:     format,
+		TempFile:   tempFile,
+	}, 200, nil
+}
+
+// localPath converts image path of file source to path inside source root.
+// Paths which leave root directly or by symlinks are not allowed
+func (s *Source) localPath(path string) (string, error) {
+	root, err := filepath.EvalSymlinks(s.Root)
+	if err != nil {
+		return "", err
+	}
+	fullPath := filepath.Join(root, filepath.FromSlash(path))
+	if resolved, err := filepath.EvalSymlinks(fullPath); err == nil {
+		fullPath = resolved
+	}
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &ForbiddenError{Reason: fmt.Sprintf("path %v is outside of source root", path)}
+	}
+	return fullPath, nil
+}
@@ -0,0 +1,163 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"ImageCutter/pkg/cropper"
+	"ImageCutter/pkg/lru"
+	"context"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestFileSource creates "root" folder with a.png, sub/b.png and symlinks inside and outside of root.
+// secret.png and root-other/secret.png are outside of root
+func newTestFileSource(t *testing.T, body []byte) (*Source, string, func()) {
+	folder, err := ioutil.TempDir("", "cutter-files")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	root := filepath.Join(folder, "root")
+	for _, dir := range []string{filepath.Join(root, "sub"), filepath.Join(folder, "root-other")} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+	}
+	for _, file := range []string{"root/a.png", "root/sub/b.png", "secret.png", "root-other/secret.png"} {
+		if err := ioutil.WriteFile(filepath.Join(folder, filepath.FromSlash(file)), body, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	links := map[string]string{
+		"root/inside-link.png":   filepath.Join(root, "a.png"),
+		"root/outside-link.png":  filepath.Join(folder, "secret.png"),
+		"root/relative-link.png": "../secret.png",
+		"root/outside-dir":       filepath.Join(folder, "root-other"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(folder, filepath.FromSlash(link))); err != nil {
+			t.Skipf("Symlink() error = %v", err)
+		}
+	}
+	source := &Source{Name: "files", Url: "file://files/", Root: root}
+	return source, folder, func() { _ = os.RemoveAll(folder) }
+}
+
+func TestSource_localPath(t *testing.T) {
+	source, _, cleanup := newTestFileSource(t, []byte("image"))
+	defer cleanup()
+	// Temp folder itself can be symlink, paths are compared with resolved root
+	root, err := filepath.EvalSymlinks(source.Root)
+	if err != nil {
+		t.Fatalf("EvalSymlinks() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string // path relative to root
+		wantErr bool
+	}{
+		{name: "File in root", path: "a.png", want: "a.png"},
+		{name: "File in subfolder", path: "sub/b.png", want: "sub/b.png"},
+		{name: "Dot segments inside root", path: "sub/../a.png", want: "a.png"},
+		{name: "Missing file", path: "missing.png", want: "missing.png"},
+		{name: "Absolute path stays in root", path: "/secret.png", want: "secret.png"},
+		{name: "Encoded dots are not decoded", path: "%2e%2e/secret.png", want: "%2e%2e/secret.png"},
+		{name: "Parent folder", path: "../secret.png", wantErr: true},
+		{name: "Parent folder from subfolder", path: "sub/../../secret.png", wantErr: true},
+		{name: "Sibling folder with root prefix", path: "../root-other/secret.png", wantErr: true},
+		{name: "Root parent itself", path: "..", wantErr: true},
+		{name: "Symlink inside root", path: "inside-link.png", want: "a.png"},
+		{name: "Symlink outside root", path: "outside-link.png", wantErr: true},
+		{name: "Relative symlink outside root", path: "relative-link.png", wantErr: true},
+		{name: "File in symlinked folder outside root", path: "outside-dir/secret.png", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := source.localPath(tt.path)
+			if tt.wantErr {
+				if _, ok := err.(*ForbiddenError); !ok {
+					t.Errorf("localPath() = %v, error = %v, want ForbiddenError", got, err)
+				}
+				return
+			}
+			if err != nil || got != filepath.Join(root, filepath.FromSlash(tt.want)) {
+				t.Errorf("localPath() = %v, error = %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutterService_resolve_FileSource(t *testing.T) {
+	cs := &CutterService{Config: &cfg.CutterConfig{}, Sources: map[string]*Source{"files": {Name: "files", Url: "file://files/", Root: "/srv/images"}}}
+	tests := []struct {
+		name     string
+		arg      string
+		want     string
+		wantCode int
+	}{
+		{name: "Image path", arg: "files/sub/b.png", want: "file://files/sub/b.png", wantCode: http.StatusOK},
+		{name: "Parent folder", arg: "files/../secret.png", wantCode: http.StatusBadRequest},
+		{name: "Parent folder from subfolder", arg: "files/sub/../../secret.png", wantCode: http.StatusBadRequest},
+		{name: "Trailing parent folder", arg: "files/sub/..", wantCode: http.StatusBadRequest},
+		{name: "Dots in file name", arg: "files/a..png", want: "file://files/a..png", wantCode: http.StatusOK},
+		{name: "Without path", arg: "files/", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, code, err := cs.resolve(tt.arg)
+			if code != tt.wantCode || got != tt.want {
+				t.Errorf("resolve() = %v, %v (error %v), want %v, %v", got, code, err, tt.want, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCutterService_Crop_FileSourceTraversal(t *testing.T) {
+	source, folder, cleanup := newTestFileSource(t, testPNG(t))
+	defer cleanup()
+	cache, err := lru.NewCache(context.Background(), zap.NewNop(), 1024*1024, filepath.Join(folder, "cache"), time.Hour, 0, 0.8)
+	if err != nil {
+		t.Fatalf("NewCache() error = %v", err)
+	}
+	defer cache.Close()
+
+	config := &cfg.CutterConfig{}
+	config.Cutter.Cache.Folder = filepath.Join(folder, "cache")
+	config.Cutter.Origin.MaxSize = 1024 * 1024
+	cs := &CutterService{Logger: zap.NewNop(), Config: config, Cache: cache, Cropper: cropper.NewCropper(zap.NewNop(), config),
+		Metrics: NewMetrics(cache, nil), Sources: map[string]*Source{"files": source}, RawSource: &Source{}}
+	router := mux.NewRouter()
+	router.HandleFunc("/crop/{width}/{height}/{url:(?:.+)}", cs.Crop)
+	router.Use(cs.accessLogMiddleware)
+
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{name: "File in root", url: "/crop/2/2/files/a.png", want: http.StatusOK},
+		{name: "Parent folder is cleaned by router", url: "/crop/2/2/files/../secret.png", want: http.StatusMovedPermanently},
+		{name: "Encoded parent folder", url: "/crop/2/2/files/%2e%2e/secret.png", want: http.StatusMovedPermanently},
+		{name: "Encoded slash after parent folder", url: "/crop/2/2/files/..%2fsecret.png", want: http.StatusMovedPermanently},
+		{name: "Double encoded parent folder", url: "/crop/2/2/files/%252e%252e/secret.png", want: http.StatusNotFound},
+		{name: "Symlink outside root", url: "/crop/2/2/files/outside-link.png", want: http.StatusForbidden},
+		{name: "Symlinked folder outside root", url: "/crop/2/2/files/outside-dir/secret.png", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodHead, tt.url, nil))
+			if w.Code != tt.want {
+				t.Errorf("Crop() code = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package cutter
+
+import (
+	"ImageCutter/pkg/models"
+	"context"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// Content types which tell nothing about image format, content is checked by magic bytes only
+var genericContentTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+}
+
+// sniffImage reads first bytes of r and detects image format. Declared content type
+// of remote server must be image of the same format or generic binary type.
+// Returns format and reader of whole content with http code
+func (cs *CutterService) sniffImage(ctx context.Context, r io.Reader, url string, contentType string) (string, io.Reader, int, error) {
+	logger := cs.log(ctx)
+	head := make([]byte, 16)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		logger.Sugar().Errorf("Reading image from url: %v give error: %v", url, err)
+		if isTimeout(err) {
+			return "", nil, 504, err
+		}
+		return "", nil, 500, err
+	}
+	head = head[:n]
+
+	format := models.DetectFormat(head)
+	if format == "" {
+		mess := fmt.Sprintf("Fetching file from url: %v is not supported image, content type: %v", url, contentType)
+		logger.Warn(mess)
+		return "", nil, 422, errors.New(mess)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !genericContentTypes[strings.ToLower(mediaType)] {
+		declared := models.FormatOf(contentType)
+		if declared != format {
+			mess := fmt.Sprintf("Fetching file from url: %v is %v image, but content type is %v", url, format, contentType)
+			logger.Warn(mess)
+			return "", nil, 422, errors.New(mess)
+		}
+	}
+
+	return format, io.MultiReader(bytes.NewReader(head), r), 200, nil
+}
@@ -0,0 +1,87 @@
+package cutter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// errorReader returns head and then err
+type errorReader struct {
+	head []byte
+	err  error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	if len(r.head) > 0 {
+		n := copy(p, r.head)
+		r.head = r.head[n:]
+		return n, nil
+	}
+	return 0, r.err
+}
+
+// timeoutError is net.Error of exceeded deadline
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCutterService_sniffImage(t *testing.T) {
+	pngImage := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), make([]byte, 32)...)
+	tests := []struct {
+		name        string
+		body        io.Reader
+		url         string
+		contentType string
+		wantFormat  string
+		wantCode    int
+	}{
+		{name: "Image of declared type", body: bytes.NewReader(pngImage), url: "http://example.com/a.png", contentType: "image/png", wantFormat: "png", wantCode: http.StatusOK},
+		{name: "Wrong extension", body: bytes.NewReader(pngImage), url: "http://example.com/a.jpg", contentType: "image/png", wantFormat: "png", wantCode: http.StatusOK},
+		{name: "Wrong extension without content type", body: bytes.NewReader(pngImage), url: "http://example.com/a.gif", wantFormat: "png", wantCode: http.StatusOK},
+		{name: "Generic content type", body: bytes.NewReader(pngImage), url: "http://example.com/a", contentType: "Application/Octet-Stream", wantFormat: "png", wantCode: http.StatusOK},
+		{name: "Content type with parameters", body: bytes.NewReader(pngImage), url: "http://example.com/a", contentType: "image/png; charset=binary", wantFormat: "png", wantCode: http.StatusOK},
+		{name: "Content type of other format", body: bytes.NewReader(pngImage), url: "http://example.com/a.jpg", contentType: "image/jpeg", wantCode: http.StatusUnprocessableEntity},
+		{name: "Content type is not image", body: bytes.NewReader(pngImage), url: "http://example.com/a.png", contentType: "text/html", wantCode: http.StatusUnprocessableEntity},
+		{name: "Unknown bytes", body: bytes.NewReader([]byte("<html><body>Not found</body></html>")), url: "http://example.com/a.png", contentType: "image/png", wantCode: http.StatusUnprocessableEntity},
+		{name: "Unknown bytes with generic content type", body: bytes.NewReader([]byte("\x00\x01\x02\x03\x04\x05\x06\x07")), url: "http://example.com/a", contentType: "application/octet-stream", wantCode: http.StatusUnprocessableEntity},
+		{name: "Truncated header", body: bytes.NewReader([]byte("\x89PNG")), url: "http://example.com/a.png", contentType: "image/png", wantCode: http.StatusUnprocessableEntity},
+		{name: "Empty body", body: bytes.NewReader(nil), url: "http://example.com/a.png", contentType: "image/png", wantCode: http.StatusUnprocessableEntity},
+		{name: "Image shorter than sniffed header", body: bytes.NewReader([]byte("GIF89a\x01\x00")), url: "http://example.com/a.gif", contentType: "image/gif", wantFormat: "gif", wantCode: http.StatusOK},
+		{name: "Read error", body: &errorReader{head: []byte("\xff\xd8"), err: errors.New("connection reset")}, url: "http://example.com/a.jpg", contentType: "image/jpeg", wantCode: http.StatusInternalServerError},
+		{name: "Read timeout", body: &errorReader{head: []byte("\xff\xd8"), err: timeoutError{}}, url: "http://example.com/a.jpg", contentType: "image/jpeg", wantCode: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &CutterService{Logger: zap.NewNop()}
+			var content []byte
+			if r, ok := tt.body.(*bytes.Reader); ok {
+				content = make([]byte, r.Len())
+				_, _ = r.ReadAt(content, 0)
+			}
+
+			format, body, code, err := cs.sniffImage(context.Background(), tt.body, tt.url, tt.contentType)
+			if code != tt.wantCode || format != tt.wantFormat {
+				t.Fatalf("sniffImage() = %q, %v, want %q, %v (error %v)", format, code, tt.wantFormat, tt.wantCode, err)
+			}
+			if (err == nil) != (code == http.StatusOK) {
+				t.Errorf("sniffImage() code = %v, error = %v", code, err)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			// Sniffed bytes are not lost
+			got, err := ioutil.ReadAll(body)
+			if err != nil || !bytes.Equal(got, content) {
+				t.Errorf("sniffImage() body = %q, %v, want %q", got, err, content)
+			}
+		})
+	}
+}
@@ -1,14 +1,21 @@
 module ImageCutter/pkg/services/cutter
 
-go 1.12
+go 1.25.0
 
 require (
 	github.com/gorilla/mux v1.7.3
+	github.com/prometheus/client_golang v1.2.1
+	go.opentelemetry.io/otel v1.45.0
+	go.opentelemetry.io/otel/sdk v1.45.0
+	go.opentelemetry.io/otel/trace v1.45.0
 	go.uber.org/zap v1.13.0
 	ImageCutter/pkg/config v0.0.0
 	ImageCutter/pkg/cropper v0.0.0
+	ImageCutter/pkg/logger v0.0.0
 	ImageCutter/pkg/lru v0.0.0
 	ImageCutter/pkg/models v0.0.0
+	ImageCutter/pkg/signing v0.0.0
+	ImageCutter/pkg/tracing v0.0.0
 )
 
 replace (
@@ -0,0 +1,133 @@
+package cutter
+
+import (
+	"ImageCutter/pkg/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	urllib "net/url"
+	"sort"
+	"strings"
+)
+
+// Headers which describe connection itself and cannot be forwarded
+var hopHeaders = map[string]bool{
+	"Connection":          true,
+	"Content-Length":      true,
+	"Host":                true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// forwardedHeaders returns client headers which are allowed to be passed to source.
+// Raw url may point to any host, so its host must be in source.ForwardHosts, otherwise
+// credentials of client would leak to host chosen by client
+func forwardedHeaders(r *http.Request, source *Source, url string) http.Header {
+	headers := make(http.Header)
+	if source.Name == "" && !source.forwardsTo(url) {
+		return headers
+	}
+	for _, name := range source.ForwardHeaders {
+		name = http.CanonicalHeaderKey(name)
+		if hopHeaders[name] {
+			continue
+		}
+		if values, ok := r.Header[name]; ok {
+			headers[name] = values
+		}
+	}
+	return headers
+}
+
+// forwardsTo reports whether host of raw url is in ForwardHosts
+func (s *Source) forwardsTo(url string) bool {
+	u, err := urllib.Parse(url)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range s.ForwardHosts {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// varyHeaders returns client headers which may be forwarded to source, so response depends on them
+func varyHeaders(source *Source) []string {
+	names := make([]string, 0, len(source.ForwardHeaders))
+	for _, name := range source.ForwardHeaders {
+		name = http.CanonicalHeaderKey(name)
+		if !hopHeaders[name] && !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// cacheKey returns url with digest of forwarded headers as fragment, so images fetched
+// with different credentials are cached separately. Fragment is never sent to remote server
+func cacheKey(url string, headers http.Header) string {
+	if len(headers) == 0 {
+		return url
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		hash.Write([]byte(name + ": " + strings.Join(headers[name], ",") + "\n"))
+	}
+	return url + "#h=" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// Headers which are set by cutter itself and never copied from remote server, so "X-*" pattern
+// does not replace request id of cutter. Names are canonical, e.g. "Etag"
+var ownHeaders = map[string]bool{
+	"Content-Encoding": true,
+	"Content-Length":   true,
+	"Content-Range":    true,
+	"Content-Type":     true,
+	"Accept-Ranges":    true,
+	"Etag":             true,
+	"Last-Modified":    true,
+	"Set-Cookie":       true,
+	"Vary":             true,
+	"X-Request-Id":     true,
+	"Traceparent":      true,
+	"Tracestate":       true,
+}
+
+// matchHeader matches header name with pattern. "X-*" matches all headers starting with "X-"
+func matchHeader(pattern string, name string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(strings.ToLower(name), strings.ToLower(strings.TrimSuffix(pattern, "*")))
+	}
+	return strings.EqualFold(pattern, name)
+}
+
+// proxyHeaders copies allowed remote server headers of image to response
+func (cs *CutterService) proxyHeaders(w http.ResponseWriter, image *models.Image) {
+	for name, value := range image.Headers {
+		name = http.CanonicalHeaderKey(name)
+		if hopHeaders[name] || ownHeaders[name] {
+			continue
+		}
+		for _, pattern := range cs.Config.Cutter.Origin.ProxyHeaders {
+			if matchHeader(pattern, name) {
+				w.Header().Set(name, value)
+				break
+			}
+		}
+	}
+}
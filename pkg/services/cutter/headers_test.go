@@ -0,0 +1,154 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"ImageCutter/pkg/lru"
+	"ImageCutter/pkg/models"
+	"context"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	named := &Source{Name: "catalog", Url: "http://10.0.0.5/images/", ForwardHeaders: []string{"Cookie", "authorization", "Connection"}}
+	raw := &Source{ForwardHeaders: []string{"Cookie", "Authorization"}, ForwardHosts: []string{"images.example.com", "*.cdn.example.com"}}
+	tests := []struct {
+		name   string
+		source *Source
+		url    string
+		want   []string
+	}{
+		{name: "Named source", source: named, url: "http://10.0.0.5/images/a.jpg", want: []string{"Cookie", "Authorization"}},
+		{name: "Raw url of allowed host", source: raw, url: "http://images.example.com/a.jpg", want: []string{"Cookie", "Authorization"}},
+		{name: "Raw url of allowed host in upper case", source: raw, url: "http://IMAGES.example.com:8080/a.jpg", want: []string{"Cookie", "Authorization"}},
+		{name: "Raw url of allowed subdomain", source: raw, url: "https://eu.cdn.example.com/a.jpg", want: []string{"Cookie", "Authorization"}},
+		{name: "Raw url of other host", source: raw, url: "http://attacker.example.org/a.jpg", want: nil},
+		{name: "Raw url with allowed host in userinfo", source: raw, url: "http://images.example.com@attacker.example.org/a.jpg", want: nil},
+		{name: "Raw url without forward hosts", source: &Source{ForwardHeaders: []string{"Cookie"}}, url: "http://images.example.com/a.jpg", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/crop/100/100/a.jpg", nil)
+			r.Header.Set("Cookie", "session=1")
+			r.Header.Set("Authorization", "Bearer secret")
+			r.Header.Set("Connection", "keep-alive")
+			r.Header.Set("X-Other", "1")
+
+			got := forwardedHeaders(r, tt.source, tt.url)
+			if len(got) != len(tt.want) {
+				t.Fatalf("forwardedHeaders() = %v, want %v", got, tt.want)
+			}
+			for _, name := range tt.want {
+				if got.Get(name) != r.Header.Get(name) {
+					t.Errorf("forwardedHeaders() %v = %q, want %q", name, got.Get(name), r.Header.Get(name))
+				}
+			}
+		})
+	}
+}
+
+func TestCutterService_CheckCache_ForwardedHeaders(t *testing.T) {
+	folder, err := ioutil.TempDir("", "cutter-cache")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(folder)
+	cache, err := lru.NewCache(context.Background(), zap.NewNop(), 1024*1024, folder, time.Hour, 0, 0.8)
+	if err != nil {
+		t.Fatalf("NewCache() error = %v", err)
+	}
+	defer cache.Close()
+
+	config := &cfg.CutterConfig{}
+	config.Cutter.Origin.AllowRawUrls = true
+	source := &Source{Name: "catalog", Url: "http://images.example.com/", ForwardHeaders: []string{"Cookie"}}
+	cs := &CutterService{Logger: zap.NewNop(), Config: config, Cache: cache, Sources: map[string]*Source{"catalog": source}, RawSource: &Source{}}
+
+	// Image is cached as Crop caches it for client with cookie
+	url := "http://images.example.com/a.jpg"
+	key := cacheKey(url, http.Header{"Cookie": []string{"session=1"}})
+	if err := ioutil.WriteFile(filepath.Join(folder, "5d41402abc4b2a76b9719d911017c592"), []byte("image"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := cache.Add(&models.Image{Name: "5d41402abc4b2a76b9719d911017c592", Url: key, Size: 5}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/cache/{url:(?:.+)}", cs.CheckCache)
+	tests := []struct {
+		name   string
+		cookie string
+		want   int
+	}{
+		{name: "Same cookie", cookie: "session=1", want: http.StatusOK},
+		{name: "Other cookie", cookie: "session=2", want: http.StatusNotFound},
+		{name: "Without cookie", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/cache/catalog/a.jpg", nil)
+			if tt.cookie != "" {
+				r.Header.Set("Cookie", tt.cookie)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("CheckCache() code = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutterService_proxyHeaders(t *testing.T) {
+	image := &models.Image{Headers: map[string]string{
+		"Cache-Control": "public, max-age=60",
+		"X-Request-Id":  "origin-request",
+		"X-Request-ID":  "origin-request",
+		"X-Cache":       "HIT",
+		"Traceparent":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"ETag":          `"origin"`,
+		"Set-Cookie":    "session=origin",
+		"Vary":          "Accept-Encoding",
+		"Connection":    "close",
+	}}
+	tests := []struct {
+		name     string
+		patterns []string
+		want     map[string]string
+	}{
+		{name: "Default Cache-Control", patterns: []string{"Cache-Control"}, want: map[string]string{"Cache-Control": "public, max-age=60"}},
+		{name: "X- headers keep request id of cutter", patterns: []string{"X-*"}, want: map[string]string{"X-Cache": "HIT"}},
+		{name: "All headers keep own headers of cutter", patterns: []string{"*"}, want: map[string]string{"Cache-Control": "public, max-age=60", "X-Cache": "HIT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &cfg.CutterConfig{}
+			config.Cutter.Origin.ProxyHeaders = tt.patterns
+			cs := &CutterService{Config: config}
+			w := httptest.NewRecorder()
+			w.Header().Set("X-Request-ID", "cutter-request")
+
+			cs.proxyHeaders(w, image)
+			want := map[string]string{"X-Request-Id": "cutter-request"}
+			for name, value := range tt.want {
+				want[name] = value
+			}
+			if len(w.Header()) != len(want) {
+				t.Errorf("proxyHeaders() headers = %v, want %v", w.Header(), want)
+			}
+			for name, value := range want {
+				if got := w.Header().Get(name); got != value {
+					t.Errorf("proxyHeaders() %v = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
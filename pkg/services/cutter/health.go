@@ -0,0 +1,114 @@
+package cutter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// HealthCheck is result of one readiness check
+type HealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type healthStatus struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthHandler reports that process is alive
+func (cs *CutterService) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	cs.writeJSON(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// ReadyHandler reports whether service can serve requests. Returns 503 if any check fails
+func (cs *CutterService) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	checks := []HealthCheck{
+		newHealthCheck("cache_folder", cs.checkCacheFolder()),
+		newHealthCheck("cache_index", cs.checkCacheIndex()),
+		newHealthCheck("draining", cs.checkDraining()),
+	}
+	if cs.Config.Cutter.Health.ProbeUrl != "" {
+		checks = append(checks, newHealthCheck("origin", cs.checkOrigin(r.Context())))
+	}
+
+	status := healthStatus{Status: "ok", Checks: checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			status.Status = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	cs.writeJSON(w, code, status)
+}
+
+func newHealthCheck(name string, err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Name: name, OK: false, Error: err.Error()}
+	}
+	return HealthCheck{Name: name, OK: true}
+}
+
+// checkCacheFolder creates and removes temp file in cache folder
+func (cs *CutterService) checkCacheFolder() error {
+	tempFile, err := ioutil.TempFile(cs.Config.Cutter.Cache.Folder, "health-*.tmp")
+	if err != nil {
+		return err
+	}
+	_ = tempFile.Close()
+	return os.Remove(tempFile.Name())
+}
+
+// checkCacheIndex fails until cache index is loaded
+func (cs *CutterService) checkCacheIndex() error {
+	if !cs.Cache.IndexLoaded() {
+		return fmt.Errorf("cache index is not loaded yet")
+	}
+	return nil
+}
+
+func (cs *CutterService) checkDraining() error {
+	if atomic.LoadInt32(&cs.draining) == 1 {
+		return fmt.Errorf("service is shutting down")
+	}
+	return nil
+}
+
+// checkOrigin sends HEAD request to probe url with client and policy of its source,
+// named source if probe url starts with source url. Any code except 5xx means origin is available
+func (cs *CutterService) checkOrigin(ctx context.Context) error {
+	healthConfig := cs.Config.Cutter.Health
+	ctx, cancel := context.WithTimeout(ctx, healthConfig.ProbeTimeout.Duration())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, healthConfig.ProbeUrl, nil)
+	if err != nil {
+		return err
+	}
+	source := cs.RawSource
+	for _, named := range cs.Sources {
+		if named.Client != nil && strings.HasPrefix(healthConfig.ProbeUrl, named.Url) {
+			source = named
+			break
+		}
+	}
+	if err := source.Policy.CheckURL(req.URL); err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", cs.Config.Cutter.Origin.UserAgent)
+	resp, err := source.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("probe url return %v code", resp.StatusCode)
+	}
+	return nil
+}
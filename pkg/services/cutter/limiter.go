@@ -0,0 +1,181 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitError is returned when fetch from remote host is rejected by HostLimiter
+type RateLimitError struct {
+	Host       string
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v: %v, retry after %v", e.Host, e.Reason, e.RetryAfter)
+}
+
+// rateLimited returns RateLimitError if err is caused by HostLimiter
+func rateLimited(err error) (*RateLimitError, bool) {
+	var limited *RateLimitError
+	ok := errors.As(err, &limited)
+	return limited, ok
+}
+
+// HostLimiter limits rate of fetches from remote host with token bucket
+// and number of fetches in flight
+type HostLimiter struct {
+	Host    string
+	rate    float64 // tokens per second, zero disables rate limit
+	burst   float64
+	tokens  float64
+	updated time.Time
+	slots   chan struct{} // nil disables concurrency limit
+	lock    sync.Mutex
+}
+
+// newHostLimiter creates limiter with full bucket. Zero rate or maxConcurrent disables limit
+func newHostLimiter(host string, rate float64, burst int, maxConcurrent int) *HostLimiter {
+	bucket := math.Max(1, float64(burst))
+	limiter := &HostLimiter{Host: host, rate: rate, burst: bucket, tokens: bucket, updated: time.Now()}
+	if maxConcurrent > 0 {
+		limiter.slots = make(chan struct{}, maxConcurrent)
+	}
+	return limiter
+}
+
+// reserve takes token and returns delay before it may be used.
+// Token is not taken if delay is longer than maxWait
+func (l *HostLimiter) reserve(maxWait time.Duration) (time.Duration, bool) {
+	if l.rate <= 0 {
+		return 0, true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.updated).Seconds()*l.rate)
+	l.updated = now
+	wait := time.Duration(math.Max(0, (1-l.tokens)/l.rate) * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+	l.tokens -= 1
+	return wait, true
+}
+
+// idle reports whether limiter has full bucket and no fetches in flight, so it does not differ from new one
+func (l *HostLimiter) idle() bool {
+	if len(l.slots) > 0 {
+		return false
+	}
+	if l.rate <= 0 {
+		return true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	return time.Since(l.updated) > refill
+}
+
+// retryAfter returns delay for Retry-After of request which waited timeout for free slot, at least one second
+func retryAfter(timeout time.Duration) time.Duration {
+	if timeout < time.Second {
+		return time.Second
+	}
+	return timeout
+}
+
+// Acquire waits up to timeout for free slot and token. Returned func releases slot after fetch
+func (l *HostLimiter) Acquire(ctx context.Context, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	release := func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case l.slots <- struct{}{}:
+			case <-timer.C:
+				// Slot is freed when fetch is done, so it is expected in about the same wait
+				return nil, &RateLimitError{Host: l.Host, Reason: "too many concurrent fetches", RetryAfter: retryAfter(timeout)}
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		release = func() { <-l.slots }
+	}
+
+	wait, ok := l.reserve(time.Until(deadline))
+	if !ok {
+		release()
+		return nil, &RateLimitError{Host: l.Host, Reason: "rate limit exceeded", RetryAfter: wait}
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// HostLimiters keeps limiter for every remote host
+type HostLimiters struct {
+	rate          float64
+	burst         int
+	maxConcurrent int
+	QueueTimeout  time.Duration
+	limiters      map[string]*HostLimiter
+	pruned        time.Time
+	lock          sync.Mutex
+}
+
+func NewHostLimiters(config cfg.Origin) *HostLimiters {
+	return &HostLimiters{
+		rate:          config.RateLimit,
+		burst:         config.RateBurst,
+		maxConcurrent: config.MaxConcurrent,
+		QueueTimeout:  config.QueueTimeout.Duration(),
+		limiters:      make(map[string]*HostLimiter),
+		pruned:        time.Now(),
+	}
+}
+
+// Get returns limiter of host, creating it if needed
+func (ls *HostLimiters) Get(host string) *HostLimiter {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	ls.prune()
+	limiter, ok := ls.limiters[host]
+	if !ok {
+		limiter = newHostLimiter(host, ls.rate, ls.burst, ls.maxConcurrent)
+		ls.limiters[host] = limiter
+	}
+	return limiter
+}
+
+// prune removes idle limiters once a minute, so map of raw url hosts does not grow with every host
+// ever requested. Called with lock held
+func (ls *HostLimiters) prune() {
+	if time.Since(ls.pruned) < time.Minute {
+		return
+	}
+	ls.pruned = time.Now()
+	for host, limiter := range ls.limiters {
+		if limiter.idle() {
+			delete(ls.limiters, host)
+		}
+	}
+}
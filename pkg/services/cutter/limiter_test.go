@@ -0,0 +1,65 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHostLimiter_AcquireRetryAfter(t *testing.T) {
+	tests := []struct {
+		name           string
+		timeout        time.Duration
+		wantRetryAfter time.Duration
+	}{
+		{name: "Short queue timeout", timeout: 10 * time.Millisecond, wantRetryAfter: time.Second},
+		{name: "Long queue timeout", timeout: 1100 * time.Millisecond, wantRetryAfter: 1100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newHostLimiter("example.com", 0, 0, 1)
+			release, err := limiter.Acquire(context.Background(), tt.timeout)
+			if err != nil {
+				t.Fatalf("Acquire() of free slot error = %v", err)
+			}
+			defer release()
+
+			_, err = limiter.Acquire(context.Background(), tt.timeout)
+			limited, ok := rateLimited(err)
+			if !ok {
+				t.Fatalf("Acquire() of busy slot error = %v, want RateLimitError", err)
+			}
+			if limited.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("Acquire() RetryAfter = %v, want %v", limited.RetryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestHostLimiters_Prune(t *testing.T) {
+	limiters := NewHostLimiters(cfg.Origin{RateLimit: 10, RateBurst: 10, MaxConcurrent: 2})
+	idle := limiters.Get("idle.example.com")
+	busy := limiters.Get("busy.example.com")
+	release, err := busy.Acquire(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer release()
+	limited := limiters.Get("limited.example.com")
+	for i := 0; i < 10; i++ {
+		limited.reserve(time.Second)
+	}
+
+	// Time passes, buckets of idle and busy hosts are refilled, bucket of limited host is empty
+	idle.updated = idle.updated.Add(-2 * time.Second)
+	busy.updated = busy.updated.Add(-2 * time.Second)
+	limiters.pruned = limiters.pruned.Add(-2 * time.Minute)
+
+	limiters.Get("new.example.com")
+	for host, want := range map[string]bool{"idle.example.com": false, "busy.example.com": true, "limited.example.com": true, "new.example.com": true} {
+		if _, ok := limiters.limiters[host]; ok != want {
+			t.Errorf("limiter of %v is kept = %v, want %v", host, ok, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package cutter
+
+import (
+	"ImageCutter/pkg/lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	urllib "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics are exposed on /metrics in Prometheus text format.
+// Every service has its own registry, so several services may live in one process
+type Metrics struct {
+	Registry         *prometheus.Registry
+	Requests         *prometheus.CounterVec
+	RequestDuration  *prometheus.HistogramVec
+	RequestsInFlight prometheus.Gauge
+	CacheEvents      *prometheus.CounterVec
+	CacheEventBytes  *prometheus.CounterVec
+	FetchDuration    *prometheus.HistogramVec
+	FetchErrors      *prometheus.CounterVec
+	CropDuration     *prometheus.HistogramVec
+	Throttled        *prometheus.CounterVec
+}
+
+func NewMetrics(cache *lru.Cache, crops *CropLimiter) *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cutter_http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cutter_http_request_duration_seconds",
+			Help:    "HTTP request duration by route.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"route"}),
+		RequestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cutter_http_requests_in_flight",
+			Help: "HTTP requests being served.",
+		}),
+		CacheEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cutter_cache_events_total",
+			Help: "Cache events: added, hit, miss, evicted, expired, deleted.",
+		}, []string{"type"}),
+		CacheEventBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cutter_cache_event_bytes_total",
+			Help: "Size of images in cache events.",
+		}, []string{"type"}),
+		FetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cutter_origin_fetch_duration_seconds",
+			Help:    "Duration of fetching original image by origin host.",
+			Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"origin"}),
+		FetchErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cutter_origin_fetch_errors_total",
+			Help: "Failed fetches by origin host and returned status code.",
+		}, []string{"origin", "code"}),
+		CropDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cutter_crop_duration_seconds",
+			Help:    "Duration of decoding, resizing and encoding image by format and output size.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"format", "size"}),
+		Throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cutter_throttled_requests_total",
+			Help: "Requests rejected by limit: client (429) or crop (503).",
+		}, []string{"limit"}),
+	}
+	m.Registry.MustRegister(
+		m.Requests, m.RequestDuration, m.RequestsInFlight,
+		m.CacheEvents, m.CacheEventBytes,
+		m.FetchDuration, m.FetchErrors, m.CropDuration, m.Throttled,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cutter_cache_size_bytes",
+			Help: "Current size of cached images.",
+		}, func() float64 {
+			currentSize, _, _ := cache.Usage()
+			return float64(currentSize)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cutter_cache_max_size_bytes",
+			Help: "Maximum size of cache.",
+		}, func() float64 {
+			_, maxSize, _ := cache.Usage()
+			return float64(maxSize)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cutter_cache_images",
+			Help: "Number of cached images.",
+		}, func() float64 {
+			_, _, images := cache.Usage()
+			return float64(images)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cutter_crops_in_progress",
+			Help: "Crops holding crop slot.",
+		}, func() float64 {
+			return float64(crops.InFlight())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cutter_crop_queue_depth",
+			Help: "Crops waiting for free crop slot.",
+		}, func() float64 {
+			return float64(crops.Waiting())
+		}),
+	)
+	return m
+}
+
+// Handler serves metrics of registry
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// CacheListener counts cache events, subscribe it with Cache.Subscribe
+func (m *Metrics) CacheListener(event lru.Event) {
+	m.CacheEvents.WithLabelValues(string(event.Type)).Inc()
+	if event.Size > 0 {
+		m.CacheEventBytes.WithLabelValues(string(event.Type)).Add(float64(event.Size))
+	}
+}
+
+// ObserveFetch records duration of fetch of url from source and error with returned code
+func (m *Metrics) ObserveFetch(source *Source, url string, started time.Time, code int, err error) {
+	label := originLabel(source, url)
+	m.FetchDuration.WithLabelValues(label).Observe(time.Since(started).Seconds())
+	if err != nil {
+		m.FetchErrors.WithLabelValues(label, strconv.Itoa(code)).Inc()
+	}
+}
+
+// ObserveCrop records crop duration. Size label is bucket of longest requested side
+func (m *Metrics) ObserveCrop(format string, width int, height int, started time.Time) {
+	side := width
+	if height > side {
+		side = height
+	}
+	size := "large"
+	switch {
+	case side <= 256:
+		size = "small"
+	case side <= 1024:
+		size = "medium"
+	}
+	m.CropDuration.WithLabelValues(format, size).Observe(time.Since(started).Seconds())
+}
+
+// originLabel returns origin host of url for metric labels. Hosts of named and file sources are configured.
+// Remote image urls are labeled by matched pattern of Origin allowhosts and other hosts share "other" label,
+// so number of series does not grow with every requested host
+func originLabel(source *Source, url string) string {
+	u, err := urllib.Parse(url)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	host := strings.ToLower(u.Hostname())
+	if source.Name != "" {
+		return host
+	}
+	if source.Policy != nil {
+		for _, pattern := range source.Policy.AllowHosts {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if pattern != "*" && matchHost(pattern, host) {
+				return pattern
+			}
+		}
+	}
+	return "other"
+}
+
+// originHost returns host of image url for access log, source name for file sources
+func originHost(url string) string {
+	u, err := urllib.Parse(url)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	return u.Host
+}
@@ -0,0 +1,28 @@
+package cutter
+
+import "testing"
+
+func TestOriginLabel(t *testing.T) {
+	raw := &Source{Policy: &OriginPolicy{AllowHosts: []string{"images.example.com", " *.CDN.example.com", "*"}}}
+	tests := []struct {
+		name   string
+		source *Source
+		url    string
+		want   string
+	}{
+		{name: "Named source", source: &Source{Name: "catalog"}, url: "http://10.0.0.5:8080/images/a.jpg", want: "10.0.0.5"},
+		{name: "File source", source: &Source{Name: "files"}, url: "file://files/a.jpg", want: "files"},
+		{name: "Raw url of allowed host", source: raw, url: "https://Images.example.com/a.jpg", want: "images.example.com"},
+		{name: "Raw url of allowed subdomain", source: raw, url: "https://eu.cdn.example.com/a.jpg", want: "*.cdn.example.com"},
+		{name: "Raw url of host allowed by any host pattern", source: raw, url: "https://attacker.example.org/a.jpg", want: "other"},
+		{name: "Raw url without allowed hosts", source: &Source{Policy: &OriginPolicy{}}, url: "https://images.example.com/a.jpg", want: "other"},
+		{name: "Broken url", source: raw, url: "http:/a.jpg", want: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := originLabel(tt.source, tt.url); got != tt.want {
+				t.Errorf("originLabel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
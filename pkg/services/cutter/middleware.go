@@ -0,0 +1,182 @@
+package cutter
+
+import (
+	logging "ImageCutter/pkg/logger"
+	"ImageCutter/pkg/tracing"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// statusWriter remembers status code and size of response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach connection of response
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func (sw *statusWriter) Status() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
+
+// Route variable patterns, "{url:(?:.+)}" is shown as "{url}"
+var routeVariable = regexp.MustCompile(`\{(\w+):[^}]*\}`)
+
+// routeName returns path template of matched route, so metrics are not labeled by image urls
+func routeName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return routeVariable.ReplaceAllString(template, "{$1}")
+		}
+	}
+	return "unknown"
+}
+
+// metricsMiddleware counts requests, their duration and requests in flight
+func (cs *CutterService) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		cs.Metrics.RequestsInFlight.Inc()
+		defer cs.Metrics.RequestsInFlight.Dec()
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		route := routeName(r)
+		cs.Metrics.Requests.WithLabelValues(route, r.Method, strconv.Itoa(sw.Status())).Inc()
+		cs.Metrics.RequestDuration.WithLabelValues(route).Observe(time.Since(started).Seconds())
+	})
+}
+
+// requestInfo is filled by handlers and written to access log
+type requestInfo struct {
+	ID      string
+	Cache   string  // "hit" or "miss" for /crop requests
+	Origin  string  // host image was fetched from
+	TraceID string  // empty if tracing is disabled
+	APIKey  string  // name of API key
+	key     *APIKey // nil if API keys are not required
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom returns info of request being served. Info is not nil for handlers called without middleware
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// log returns logger with request id of ctx
+func (cs *CutterService) log(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, cs.Logger)
+}
+
+// Request ids given by clients are used if they are short and safe to log
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,128}$`)
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(id)
+}
+
+// accessLogMiddleware assigns X-Request-ID, passes logger with request id to handlers
+// and writes one log entry per request
+func (cs *CutterService) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		info := &requestInfo{ID: id}
+		logger := cs.Logger.With(zap.String("request_id", id))
+		ctx := context.WithValue(logging.WithLogger(r.Context(), logger), requestInfoKey{}, info)
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		logger.Info("HTTP request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", sw.Status()),
+			zap.Int64("bytes", sw.bytes),
+			zap.Float64("duration", time.Since(started).Seconds()),
+			zap.String("cache", info.Cache),
+			zap.String("origin", info.Origin),
+			zap.String("trace_id", info.TraceID),
+			zap.String("api_key", info.APIKey),
+			zap.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// tracingMiddleware starts server span of request. Trace of client is continued if request has
+// traceparent header, trace id is added to logs of request
+func (cs *CutterService) tracingMiddleware(next http.Handler) http.Handler {
+	if cs.Tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeName(r)
+		ctx, span := cs.Tracer.StartRoot(r.Context(), r.Method+" "+route, r.Header)
+		defer span.End()
+		span.SetAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("http.target", r.URL.Path), // query may contain API key
+		)
+
+		info := requestInfoFrom(ctx)
+		info.TraceID = span.SpanContext().TraceID().String()
+		span.SetAttributes(attribute.String("request_id", info.ID))
+		logger := cs.log(ctx).With(zap.String("trace_id", info.TraceID))
+		ctx = logging.WithLogger(ctx, logger)
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", sw.Status()))
+		if sw.Status() >= 500 {
+			tracing.SetError(span, fmt.Errorf("request failed with %v code", sw.Status()))
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"errors"
+	"fmt"
+	"net"
+	urllib "net/url"
+	"strings"
+	"syscall"
+)
+
+// ForbiddenError is returned when origin is not allowed by OriginPolicy
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Reason
+}
+
+// isForbidden reports whether err is caused by OriginPolicy
+func isForbidden(err error) bool {
+	var forbidden *ForbiddenError
+	return errors.As(err, &forbidden)
+}
+
+// Addresses which are not allowed without allowprivate
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8",      // "this" network
+	"10.0.0.0/8",     // RFC1918
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, cloud metadata
+	"172.16.0.0/12",  // RFC1918
+	"192.168.0.0/16", // RFC1918
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"64:ff9b::/96",   // NAT64, embeds any IPv4 address
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// OriginPolicy decides which remote servers cutter may fetch images from
+type OriginPolicy struct {
+	Schemes      []string
+	AllowHosts   []string
+	DenyHosts    []string
+	AllowPrivate bool
+	PrivateHosts []string // hosts which may resolve to private addresses, e.g. host of named source
+}
+
+func NewOriginPolicy(config cfg.Origin) *OriginPolicy {
+	return &OriginPolicy{
+		Schemes:      config.Schemes,
+		AllowHosts:   config.AllowHosts,
+		DenyHosts:    config.DenyHosts,
+		AllowPrivate: config.AllowPrivate,
+	}
+}
+
+// CheckURL checks url scheme and host. Host addresses are checked later by CheckAddress
+func (p *OriginPolicy) CheckURL(u *urllib.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	if !containsFold(p.Schemes, scheme) {
+		return &ForbiddenError{Reason: fmt.Sprintf("url scheme %v is not allowed", u.Scheme)}
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range p.DenyHosts {
+		if matchHost(pattern, host) {
+			return &ForbiddenError{Reason: fmt.Sprintf("host %v is denied", host)}
+		}
+	}
+	if len(p.AllowHosts) == 0 {
+		return nil
+	}
+	for _, pattern := range p.AllowHosts {
+		if matchHost(pattern, host) {
+			return nil
+		}
+	}
+	return &ForbiddenError{Reason: fmt.Sprintf("host %v is not in allowed hosts", host)}
+}
+
+// allowsPrivate reports whether host may resolve to private address
+func (p *OriginPolicy) allowsPrivate(host string) bool {
+	return p.AllowPrivate || containsFold(p.PrivateHosts, host)
+}
+
+// CheckAddress checks resolved ip address of remote server
+func (p *OriginPolicy) CheckAddress(ip net.IP) error {
+	if p.AllowPrivate {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return &ForbiddenError{Reason: fmt.Sprintf("address %v is not allowed", ip)}
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return &ForbiddenError{Reason: fmt.Sprintf("address %v is in private network %v", ip, network)}
+		}
+	}
+	return nil
+}
+
+// Control is used as net.Dialer.Control. It is called after DNS resolution with real address,
+// so host which resolves to private address is blocked even if it passed CheckURL
+func (p *OriginPolicy) Control(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &ForbiddenError{Reason: fmt.Sprintf("address %v is not ip", host)}
+	}
+	return p.CheckAddress(ip)
+}
+
+// matchHost matches host with pattern. "*.example.com" matches subdomains of example.com, "*" matches all hosts
+func matchHost(pattern string, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	urllib "net/url"
+	"testing"
+)
+
+func TestOriginPolicy_CheckURL(t *testing.T) {
+	policy := &OriginPolicy{
+		Schemes:    []string{"http", "https"},
+		AllowHosts: []string{"images.example.com", "*.cdn.example.com"},
+		DenyHosts:  []string{"private.cdn.example.com"},
+	}
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "Allowed host", url: "https://images.example.com/a.jpg", wantErr: false},
+		{name: "Allowed host in upper case", url: "https://IMAGES.example.com/a.jpg", wantErr: false},
+		{name: "Allowed subdomain", url: "http://eu.cdn.example.com/a.jpg", wantErr: false},
+		{name: "Wildcard does not match domain itself", url: "http://cdn.example.com/a.jpg", wantErr: true},
+		{name: "Denied subdomain", url: "http://private.cdn.example.com/a.jpg", wantErr: true},
+		{name: "Host not in allowed hosts", url: "http://example.org/a.jpg", wantErr: true},
+		{name: "Scheme not allowed", url: "ftp://images.example.com/a.jpg", wantErr: true},
+		{name: "File scheme", url: "file:///etc/passwd", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := urllib.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("CheckURL() cannot parse url %v: %v", tt.url, err)
+			}
+			err = policy.CheckURL(u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !isForbidden(err) {
+				t.Errorf("CheckURL() error = %v is not ForbiddenError", err)
+			}
+		})
+	}
+}
+
+func TestOriginPolicy_CheckAddress(t *testing.T) {
+	tests := []struct {
+		name         string
+		ip           string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{name: "Public IPv4", ip: "93.184.216.34", wantErr: false},
+		{name: "Public IPv6", ip: "2606:2800:220:1:248:1893:25c8:1946", wantErr: false},
+		{name: "Loopback", ip: "127.0.0.1", wantErr: true},
+		{name: "Loopback in 127/8", ip: "127.10.0.1", wantErr: true},
+		{name: "IPv6 loopback", ip: "::1", wantErr: true},
+		{name: "Unspecified", ip: "0.0.0.0", wantErr: true},
+		{name: "IPv6 unspecified", ip: "::", wantErr: true},
+		{name: "Cloud metadata", ip: "169.254.169.254", wantErr: true},
+		{name: "RFC1918 10/8", ip: "10.1.2.3", wantErr: true},
+		{name: "RFC1918 172.16/12", ip: "172.31.255.255", wantErr: true},
+		{name: "Outside 172.16/12", ip: "172.32.0.1", wantErr: false},
+		{name: "RFC1918 192.168/16", ip: "192.168.0.1", wantErr: true},
+		{name: "Carrier-grade NAT", ip: "100.64.0.1", wantErr: true},
+		{name: "IPv6 unique local", ip: "fd00::1", wantErr: true},
+		{name: "IPv6 link-local", ip: "fe80::1", wantErr: true},
+		{name: "IPv4-mapped loopback", ip: "::ffff:127.0.0.1", wantErr: true},
+		{name: "IPv4-mapped metadata", ip: "::ffff:169.254.169.254", wantErr: true},
+		{name: "NAT64 metadata", ip: "64:ff9b::a9fe:a9fe", wantErr: true},
+		{name: "NAT64 public", ip: "64:ff9b::5db8:d822", wantErr: true},
+		{name: "Private allowed", ip: "10.1.2.3", allowPrivate: true, wantErr: false},
+		{name: "Loopback allowed", ip: "127.0.0.1", allowPrivate: true, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &OriginPolicy{AllowPrivate: tt.allowPrivate}
+			ip := net.ParseIP(tt.ip)
+			if ip == nil {
+				t.Fatalf("CheckAddress() cannot parse ip %v", tt.ip)
+			}
+			if err := policy.CheckAddress(ip); (err != nil) != tt.wantErr {
+				t.Errorf("CheckAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOriginPolicy_Redirects(t *testing.T) {
+	image := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer image.Close()
+	imageURL, _ := urllib.Parse(image.URL)
+	_, port, _ := net.SplitHostPort(imageURL.Host)
+
+	// Source host is 127.0.0.1, so only it may be private. localhost resolves to loopback too, but is another host
+	redirects := map[string]string{
+		"/same-host":  "http://127.0.0.1:" + port + "/a.png",
+		"/localhost":  "http://localhost:" + port + "/a.png",
+		"/metadata":   "http://169.254.169.254/latest/meta-data/",
+		"/denied":     "http://denied.example.com/a.png",
+		"/bad-scheme": "file:///etc/passwd",
+	}
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if location, ok := redirects[r.URL.Path]; ok {
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer source.Close()
+
+	originConfig := cfg.Origin{
+		Schemes:      []string{"http", "https"},
+		DenyHosts:    []string{"denied.example.com"},
+		MaxRedirects: 3,
+	}
+	policy := NewOriginPolicy(originConfig)
+	policy.PrivateHosts = []string{"127.0.0.1"}
+	client := NewOriginClient(originConfig, policy)
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "Redirect to source host", path: "/same-host", wantErr: false},
+		{name: "Redirect to other loopback host", path: "/localhost", wantErr: true},
+		{name: "Redirect to metadata address", path: "/metadata", wantErr: true},
+		{name: "Redirect to denied host", path: "/denied", wantErr: true},
+		{name: "Redirect to file scheme", path: "/bad-scheme", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(source.URL + tt.path)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !isForbidden(err) {
+				t.Errorf("Get() error = %v is not ForbiddenError", err)
+			}
+		})
+	}
+
+	// Policy without private hosts does not connect to source itself
+	strict := NewOriginClient(originConfig, NewOriginPolicy(originConfig))
+	if resp, err := strict.Get(source.URL + "/same-host"); err == nil {
+		_ = resp.Body.Close()
+		t.Errorf("Get() of loopback source without private hosts is not rejected")
+	} else if !isForbidden(err) {
+		t.Errorf("Get() error = %v is not ForbiddenError", err)
+	}
+}
+
+func TestOriginPolicy_Control(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("SplitHostPort() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		address      string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{name: "Host resolved to loopback", address: net.JoinHostPort("localhost", port), wantErr: true},
+		{name: "Loopback address", address: net.JoinHostPort("127.0.0.1", port), wantErr: true},
+		{name: "Private addresses allowed", address: net.JoinHostPort("localhost", port), allowPrivate: true, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &OriginPolicy{AllowPrivate: tt.allowPrivate}
+			dialer := &net.Dialer{Control: policy.Control}
+			conn, err := dialer.Dial("tcp4", tt.address)
+			if err == nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !isForbidden(err) {
+				t.Errorf("Dial() error = %v, want ForbiddenError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package cutter
+
+import (
+	"ImageCutter/pkg/signing"
+	"net/http"
+	"time"
+)
+
+// signatureMiddleware rejects requests without valid url signature with 403.
+// Does nothing if signing keys are not configured
+func (cs *CutterService) signatureMiddleware(next http.Handler) http.Handler {
+	keys := cs.Config.Cutter.Signing.Keys
+	if len(keys) == 0 {
+		return next
+	}
+	verifier := signing.NewVerifier(keys)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifier.Verify(r.URL.Path, r.URL.Query(), time.Now()); err != nil {
+			cs.log(r.Context()).Sugar().Warnf("Request %v is rejected: %v", r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
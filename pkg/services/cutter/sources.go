@@ -0,0 +1,102 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"fmt"
+	"net/http"
+	urllib "net/url"
+	"strings"
+)
+
+// Source is remote server images are fetched from
+type Source struct {
+	Name           string            // empty for raw urls
+	Url            string            // base url of named source, "file://{name}/" for file sources
+	Root           string            // root folder of file source
+	Headers        map[string]string // static headers
+	ForwardHeaders []string          // client headers passed to source
+	ForwardHosts   []string          // raw url hosts which get ForwardHeaders, named source always gets them
+	NoCache        bool
+	Policy         *OriginPolicy
+	Client         *http.Client
+}
+
+// NewSources creates named sources from config. Source urls are set by administrator,
+// so source hosts may be in private networks and are allowed even if they are not in Origin allowhosts.
+// Hosts denied by Origin config are still denied
+func NewSources(config *cfg.CutterConfig) map[string]*Source {
+	sources := make(map[string]*Source)
+	for name, sourceConfig := range config.Cutter.Sources {
+		if sourceConfig.Type == cfg.SourceFile {
+			sources[name] = &Source{
+				Name:    name,
+				Url:     fmt.Sprintf("file://%v/", name),
+				Root:    sourceConfig.Root,
+				NoCache: sourceConfig.NoCache,
+			}
+			continue
+		}
+		originConfig := config.Cutter.Origin
+		if sourceConfig.ConnectTimeout > 0 {
+			originConfig.ConnectTimeout = sourceConfig.ConnectTimeout
+		}
+		if sourceConfig.HeaderTimeout > 0 {
+			originConfig.HeaderTimeout = sourceConfig.HeaderTimeout
+		}
+		if sourceConfig.Timeout > 0 {
+			originConfig.Timeout = sourceConfig.Timeout
+		}
+		// Only source host is exempt from private address check, redirects to other hosts are checked by Origin policy
+		policy := NewOriginPolicy(originConfig)
+		if u, err := urllib.Parse(sourceConfig.Url); err == nil && u.Hostname() != "" {
+			policy.PrivateHosts = []string{u.Hostname()}
+			if len(policy.AllowHosts) > 0 {
+				policy.AllowHosts = append(append([]string{}, policy.AllowHosts...), u.Hostname())
+			}
+		}
+		sources[name] = &Source{
+			Name:           name,
+			Url:            strings.TrimSuffix(sourceConfig.Url, "/") + "/",
+			Headers:        sourceConfig.Headers,
+			ForwardHeaders: append(append([]string{}, originConfig.ForwardHeaders...), sourceConfig.ForwardHeaders...),
+			NoCache:        sourceConfig.NoCache,
+			Policy:         policy,
+			Client:         NewOriginClient(originConfig, policy),
+		}
+	}
+	return sources
+}
+
+// resolve converts url argument of request to remote image url and its source.
+// Argument is either "{source}/{path}" for named sources or full remote url.
+// Returns http code with error
+func (cs *CutterService) resolve(arg string) (string, *Source, int, error) {
+	// Named source
+	parts := strings.SplitN(arg, "/", 2)
+	if source, ok := cs.Sources[parts[0]]; ok {
+		if len(parts) < 2 || parts[1] == "" {
+			return "", nil, 400, fmt.Errorf("Image path is required for source: %v", source.Name)
+		}
+		for _, segment := range strings.Split(parts[1], "/") {
+			if segment == ".." {
+				return "", nil, 400, fmt.Errorf("Image path must not contain '..': %v", parts[1])
+			}
+		}
+		return source.Url + parts[1], source, 200, nil
+	}
+
+	// Raw url
+	url := arg
+	u, err := urllib.Parse(url)
+	if err != nil || u.Scheme == "" {
+		return "", nil, 400, fmt.Errorf("Remote image url is incorrect: %v. Protocol is missed(require http:// or https://) or source is unknown", url)
+	}
+	if !cs.Config.Cutter.Origin.AllowRawUrls {
+		return "", nil, 403, fmt.Errorf("Remote image urls are not allowed, use one of configured sources")
+	}
+	// Url scheme must contains two slash not one
+	if u.Host == "" {
+		url = fmt.Sprintf("%v:/%v", u.Scheme, u.Path)
+	}
+	return url, cs.RawSource, 200, nil
+}
@@ -0,0 +1,165 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CropLimiter limits number of crops in progress, so bursts do not starve CPU.
+// Crops wait for free slot in bounded queue
+type CropLimiter struct {
+	waiting  int64         // crops in queue, first field, so it is 64-bit aligned for atomic access on 32-bit platforms
+	slots    chan struct{} // nil disables limit
+	maxQueue int64
+	timeout  time.Duration
+}
+
+func NewCropLimiter(config cfg.Limits) *CropLimiter {
+	limiter := &CropLimiter{maxQueue: int64(config.CropQueue), timeout: config.CropQueueTimeout.Duration()}
+	if config.MaxCrops > 0 {
+		limiter.slots = make(chan struct{}, config.MaxCrops)
+	}
+	return limiter
+}
+
+// Acquire takes crop slot. If all slots are busy, waits in queue up to timeout.
+// Returned func releases slot after crop
+func (l *CropLimiter) Acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if atomic.AddInt64(&l.waiting, 1) > l.maxQueue {
+		atomic.AddInt64(&l.waiting, -1)
+		return nil, &RateLimitError{Host: "cropper", Reason: "crop queue is full", RetryAfter: retryAfter(l.timeout)}
+	}
+	defer atomic.AddInt64(&l.waiting, -1)
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, &RateLimitError{Host: "cropper", Reason: "no free crop slot", RetryAfter: retryAfter(l.timeout)}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// InFlight returns number of crops in progress
+func (l *CropLimiter) InFlight() int {
+	return len(l.slots)
+}
+
+// Waiting returns number of crops in queue
+func (l *CropLimiter) Waiting() int64 {
+	return atomic.LoadInt64(&l.waiting)
+}
+
+// ClientLimiters keeps token bucket for every client IP
+type ClientLimiters struct {
+	rate           float64 // zero disables limit
+	burst          int
+	trustForwarded bool
+	limiters       map[string]*HostLimiter
+	pruned         time.Time
+	lock           sync.Mutex
+}
+
+func NewClientLimiters(config cfg.Limits) *ClientLimiters {
+	return &ClientLimiters{
+		rate:           config.ClientRate,
+		burst:          config.ClientBurst,
+		trustForwarded: config.TrustForwarded,
+		limiters:       make(map[string]*HostLimiter),
+		pruned:         time.Now(),
+	}
+}
+
+// Allow takes token of client. Returns delay before next token if client has no tokens
+func (ls *ClientLimiters) Allow(client string) (time.Duration, bool) {
+	ls.lock.Lock()
+	ls.prune()
+	limiter, ok := ls.limiters[client]
+	if !ok {
+		limiter = newHostLimiter(client, ls.rate, ls.burst, 0)
+		ls.limiters[client] = limiter
+	}
+	ls.lock.Unlock()
+	return limiter.reserve(0)
+}
+
+// prune removes buckets which are full again, they do not differ from new ones. Called with lock held
+func (ls *ClientLimiters) prune() {
+	refill := time.Duration(math.Max(1, float64(ls.burst)) / ls.rate * float64(time.Second))
+	if time.Since(ls.pruned) < time.Minute || time.Since(ls.pruned) < refill {
+		return
+	}
+	ls.pruned = time.Now()
+	for client, limiter := range ls.limiters {
+		limiter.lock.Lock()
+		idle := time.Since(limiter.updated)
+		limiter.lock.Unlock()
+		if idle > refill {
+			delete(ls.limiters, client)
+		}
+	}
+}
+
+// clientIP returns address of client. Behind reverse proxy it is last address of X-Forwarded-For,
+// addresses before it are given by client and may be forged. Proxy may append its own header line
+// instead of joining addresses, so last address of last line is taken
+func (ls *ClientLimiters) clientIP(r *http.Request) string {
+	if ls.trustForwarded {
+		if lines := r.Header.Values("X-Forwarded-For"); len(lines) > 0 {
+			addresses := strings.Split(lines[len(lines)-1], ",")
+			if client := strings.TrimSpace(addresses[len(addresses)-1]); client != "" {
+				return client
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// throttleMiddleware returns 429 with Retry-After if client IP exceeds its request rate.
+// Health checks and metrics are not limited
+func (cs *CutterService) throttleMiddleware(next http.Handler) http.Handler {
+	if cs.Clients.rate <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := operationOf(r.URL.Path); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := cs.Clients.clientIP(r)
+		if wait, ok := cs.Clients.Allow(client); !ok {
+			cs.Metrics.Throttled.WithLabelValues("client").Inc()
+			mess := fmt.Sprintf("Too many requests from %v", client)
+			cs.log(r.Context()).Warn(mess)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, mess, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,119 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCropLimiter_Acquire(t *testing.T) {
+	limiter := NewCropLimiter(cfg.Limits{MaxCrops: 1, CropQueue: 1, CropQueueTimeout: cfg.Duration(time.Second)})
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() of free slot error = %v", err)
+	}
+	if limiter.InFlight() != 1 {
+		t.Errorf("InFlight() = %v, want 1", limiter.InFlight())
+	}
+
+	// Second crop waits in queue until slot is released
+	queued := make(chan error)
+	go func() {
+		release, err := limiter.Acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		queued <- err
+	}()
+	for limiter.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Third crop does not fit in queue
+	_, err = limiter.Acquire(context.Background())
+	limited, ok := rateLimited(err)
+	if !ok {
+		t.Fatalf("Acquire() with full queue error = %v, want RateLimitError", err)
+	}
+	if limited.RetryAfter != time.Second {
+		t.Errorf("Acquire() with full queue RetryAfter = %v, want %v", limited.RetryAfter, time.Second)
+	}
+	if limiter.Waiting() != 1 {
+		t.Errorf("Waiting() after rejected crop = %v, want 1", limiter.Waiting())
+	}
+
+	release()
+	if err := <-queued; err != nil {
+		t.Errorf("Acquire() in queue error = %v", err)
+	}
+	if limiter.InFlight() != 0 || limiter.Waiting() != 0 {
+		t.Errorf("InFlight() = %v, Waiting() = %v after all crops, want 0", limiter.InFlight(), limiter.Waiting())
+	}
+}
+
+func TestCropLimiter_AcquireTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		timeout        time.Duration
+		wantRetryAfter time.Duration
+	}{
+		{name: "Short queue timeout", timeout: 10 * time.Millisecond, wantRetryAfter: time.Second},
+		{name: "Long queue timeout", timeout: 1100 * time.Millisecond, wantRetryAfter: 1100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewCropLimiter(cfg.Limits{MaxCrops: 1, CropQueue: 1, CropQueueTimeout: cfg.Duration(tt.timeout)})
+			release, err := limiter.Acquire(context.Background())
+			if err != nil {
+				t.Fatalf("Acquire() of free slot error = %v", err)
+			}
+			defer release()
+
+			_, err = limiter.Acquire(context.Background())
+			limited, ok := rateLimited(err)
+			if !ok {
+				t.Fatalf("Acquire() of busy slot error = %v, want RateLimitError", err)
+			}
+			if limited.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("Acquire() RetryAfter = %v, want %v", limited.RetryAfter, tt.wantRetryAfter)
+			}
+			if limiter.Waiting() != 0 {
+				t.Errorf("Waiting() after timeout = %v, want 0", limiter.Waiting())
+			}
+		})
+	}
+}
+
+func TestClientLimiters_clientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustForwarded bool
+		forwarded      []string
+		want           string
+	}{
+		{name: "Remote address", want: "192.0.2.1"},
+		{name: "Forwarded header is not trusted", forwarded: []string{"198.51.100.7"}, want: "192.0.2.1"},
+		{name: "Single forwarded address", trustForwarded: true, forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "Last of joined addresses", trustForwarded: true, forwarded: []string{"203.0.113.9, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "Last of header lines", trustForwarded: true, forwarded: []string{"203.0.113.9", "198.51.100.7"}, want: "198.51.100.7"},
+		{name: "Forged line before proxy line", trustForwarded: true, forwarded: []string{"203.0.113.9, 10.0.0.1", "198.51.100.7, 198.51.100.8"}, want: "198.51.100.8"},
+		{name: "Empty forwarded address", trustForwarded: true, forwarded: []string{"198.51.100.7, "}, want: "192.0.2.1"},
+		{name: "No forwarded header", trustForwarded: true, want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiters := NewClientLimiters(cfg.Limits{ClientRate: 1, ClientBurst: 1, TrustForwarded: tt.trustForwarded})
+			r := httptest.NewRequest(http.MethodGet, "/crop/100/50/images/a.png", nil)
+			r.RemoteAddr = "192.0.2.1:53412"
+			for _, line := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", line)
+			}
+			if got := limiters.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package cutter
+
+import (
+	cfg "ImageCutter/pkg/config"
+	"ImageCutter/pkg/tracing"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"os"
+)
+
+// NewTracer creates tracer with exporter of config. Returns nil if tracing is disabled
+// or exporter cannot be created
+func NewTracer(logger *zap.Logger, config cfg.Tracing) *tracing.Tracer {
+	var exporter trace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case cfg.TracingStdout:
+		exporter, err = tracing.NewWriterExporter(os.Stdout)
+	case cfg.TracingOTLP:
+		exporter, err = tracing.NewOTLPExporter(config.Endpoint, config.Timeout.Duration())
+	default:
+		return nil
+	}
+	if err != nil {
+		logger.Sugar().Errorf("Creating %v span exporter give error: %v. Tracing is disabled", config.Exporter, err)
+		return nil
+	}
+	logger.Sugar().Infof("Spans will be exported to %v with sample ratio %v", config.Exporter, config.SampleRatio)
+	return tracing.NewTracer(exporter, config.ServiceName, config.SampleRatio, config.TrustParent, config.QueueSize, func(err error) {
+		logger.Sugar().Warnf("Exporting spans give error: %v", err)
+	})
+}
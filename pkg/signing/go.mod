@@ -0,0 +1,3 @@
+module ImageCutter/pkg/signing
+
+go 1.12
@@ -0,0 +1,88 @@
+package signing
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "", want: "/"},
+		{path: "crop/1/2/a.jpg", want: "/crop/1/2/a.jpg"},
+		{path: "/crop/1/2//catalog/./a.jpg", want: "/crop/1/2/catalog/a.jpg"},
+		{path: "/crop/1/2/catalog/../a.jpg", want: "/crop/1/2/a.jpg"},
+		{path: "/crop/1/2/http://example.com/a.jpg", want: "/crop/1/2/http:/example.com/a.jpg"},
+		{path: "/crop/1/2/catalog/", want: "/crop/1/2/catalog/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := CleanPath(tt.path); got != tt.want {
+				t.Errorf("CleanPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	const p = "/crop/300/200/catalog/shoes/1.jpg"
+	oldKey, newKey := []byte("old-secret"), []byte("new-secret")
+	now := time.Unix(1700000000, 0)
+
+	// query returns query of url signed by SignPath
+	query := func(key []byte, p string, expires time.Time) url.Values {
+		signed, err := url.Parse(SignPath(key, p, expires))
+		if err != nil {
+			t.Fatalf("SignPath() returns broken url: %v", err)
+		}
+		return signed.Query()
+	}
+	tampered := func(query url.Values, name, value string) url.Values {
+		query.Set(name, value)
+		return query
+	}
+
+	tests := []struct {
+		name    string
+		keys    []string
+		path    string
+		query   url.Values
+		wantErr error
+	}{
+		{name: "Valid signature", keys: []string{"old-secret"}, path: p, query: query(oldKey, p, time.Time{})},
+		{name: "Rotation: url signed with new key", keys: []string{"new-secret", "old-secret"}, path: p, query: query(newKey, p, time.Time{})},
+		{name: "Rotation: url signed with old key", keys: []string{"new-secret", "old-secret"}, path: p, query: query(oldKey, p, time.Time{})},
+		{name: "Rotation: old key is removed", keys: []string{"new-secret"}, path: p, query: query(oldKey, p, time.Time{}), wantErr: ErrInvalid},
+		{name: "Not expired", keys: []string{"old-secret"}, path: p, query: query(oldKey, p, now.Add(time.Minute))},
+		{name: "Expires now", keys: []string{"old-secret"}, path: p, query: query(oldKey, p, now)},
+		{name: "Expired", keys: []string{"old-secret"}, path: p, query: query(oldKey, p, now.Add(-time.Second)), wantErr: ErrExpired},
+		{name: "Expiry is extended", keys: []string{"old-secret"}, path: p, query: tampered(query(oldKey, p, now.Add(-time.Second)), ExpiresParam, "1800000000"), wantErr: ErrInvalid},
+		{name: "Expiry is removed", keys: []string{"old-secret"}, path: p, query: url.Values{SignatureParam: {query(oldKey, p, now).Get(SignatureParam)}}, wantErr: ErrInvalid},
+		{name: "Broken expiry", keys: []string{"old-secret"}, path: p, query: tampered(query(oldKey, p, now), ExpiresParam, "tomorrow"), wantErr: ErrInvalid},
+		{name: "Negative expiry", keys: []string{"old-secret"}, path: p, query: tampered(query(oldKey, p, now), ExpiresParam, "-1"), wantErr: ErrInvalid},
+		{name: "Tampered size", keys: []string{"old-secret"}, path: "/crop/3000/2000/catalog/shoes/1.jpg", query: query(oldKey, p, time.Time{}), wantErr: ErrInvalid},
+		{name: "Tampered image", keys: []string{"old-secret"}, path: "/crop/300/200/catalog/shoes/2.jpg", query: query(oldKey, p, time.Time{}), wantErr: ErrInvalid},
+		{name: "Same path with dot segments", keys: []string{"old-secret"}, path: "/crop/300/200/catalog/./shoes//1.jpg", query: query(oldKey, p, time.Time{})},
+		{name: "Tampered signature", keys: []string{"old-secret"}, path: p, query: tampered(query(oldKey, p, time.Time{}), SignatureParam, Signature(newKey, p, 0)), wantErr: ErrInvalid},
+		{name: "Missing signature", keys: []string{"old-secret"}, path: p, query: url.Values{}, wantErr: ErrMissing},
+		{name: "No keys", path: p, query: query(oldKey, p, time.Time{}), wantErr: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewVerifier(tt.keys).Verify(tt.path, tt.query, now); err != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignURL(t *testing.T) {
+	got := SignURL("https://cutter.example.com/", []byte("secret"), "crop/300/200/1.jpg", time.Unix(1700000000, 0))
+	want := "https://cutter.example.com/crop/300/200/1.jpg?expires=1700000000&signature=" + Signature([]byte("secret"), "/crop/300/200/1.jpg", 1700000000)
+	if got != want {
+		t.Errorf("SignURL() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"io"
+	"time"
+)
+
+// NewWriterExporter creates exporter which writes spans as JSON, e.g. to stdout
+func NewWriterExporter(writer io.Writer) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(writer))
+}
+
+// NewOTLPExporter creates exporter which posts spans to OpenTelemetry collector with OTLP/HTTP,
+// endpoint is traces url, e.g. http://collector:4318/v1/traces
+func NewOTLPExporter(endpoint string, timeout time.Duration) (sdktrace.SpanExporter, error) {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+	if timeout > 0 {
+		options = append(options, otlptracehttp.WithTimeout(timeout))
+	}
+	return otlptracehttp.New(context.Background(), options...)
+}
+
+// errorExporter passes export errors to onError instead of global handler of OpenTelemetry
+type errorExporter struct {
+	sdktrace.SpanExporter
+	onError func(error)
+}
+
+func (e *errorExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if err := e.SpanExporter.ExportSpans(ctx, spans); err != nil {
+		e.onError(err)
+	}
+	return nil
+}
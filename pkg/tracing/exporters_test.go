@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporter_Export(t *testing.T) {
+	var path, contentType string
+	var body []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		contentType = r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(collector.URL+"/v1/traces", time.Second)
+	if err != nil {
+		t.Fatalf("NewOTLPExporter() error = %v", err)
+	}
+	var exportErr error
+	tracer := NewTracer(exporter, "image-cutter", 1, false, 10, func(err error) { exportErr = err })
+	ctx, root := tracer.StartRoot(context.Background(), "GET /crop", http.Header{})
+	root.SetAttributes(attribute.String("http.method", "GET"), attribute.Int("http.status_code", 200))
+	_, child := Start(ctx, "cropper.Crop")
+	SetError(child, errors.New("image header is broken"))
+	child.End()
+	root.End()
+	tracer.Close()
+
+	if exportErr != nil {
+		t.Fatalf("Close() export error = %v", exportErr)
+	}
+	if path != "/v1/traces" || contentType != "application/x-protobuf" {
+		t.Errorf("Close() posted %v to %v, want protobuf to /v1/traces", contentType, path)
+	}
+	request := &collectortrace.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		t.Fatalf("Close() body is not OTLP request: %v", err)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Close() exported %v resource spans, want 1 with 1 scope", len(request.ResourceSpans))
+	}
+	attributes := request.ResourceSpans[0].Resource.Attributes
+	if len(attributes) != 1 || attributes[0].Key != "service.name" || attributes[0].Value.GetStringValue() != "image-cutter" {
+		t.Errorf("Close() resource attributes = %v, want service.name", attributes)
+	}
+	scope := request.ResourceSpans[0].ScopeSpans[0]
+	if scope.Scope.Name != "ImageCutter" {
+		t.Errorf("Close() scope = %v, want ImageCutter", scope.Scope.Name)
+	}
+	if len(scope.Spans) != 2 {
+		t.Fatalf("Close() exported %v spans, want 2", len(scope.Spans))
+	}
+	gotChild, gotRoot := scope.Spans[0], scope.Spans[1]
+	if gotRoot.Name != "GET /crop" || len(gotRoot.Attributes) != 2 {
+		t.Errorf("Close() root = %v with attributes %v", gotRoot.Name, gotRoot.Attributes)
+	}
+	if string(gotChild.ParentSpanId) != string(gotRoot.SpanId) {
+		t.Errorf("Close() child parent = %x, want %x", gotChild.ParentSpanId, gotRoot.SpanId)
+	}
+	if gotChild.Status.GetMessage() != "image header is broken" || gotChild.Status.GetCode().String() != "STATUS_CODE_ERROR" {
+		t.Errorf("Close() child status = %v, want error", gotChild.Status)
+	}
+}
+
+func TestOTLPExporter_ExportError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(collector.URL+"/v1/traces", time.Second)
+	if err != nil {
+		t.Fatalf("NewOTLPExporter() error = %v", err)
+	}
+	var exportErr error
+	tracer := NewTracer(exporter, "image-cutter", 1, false, 10, func(err error) { exportErr = err })
+	_, span := tracer.StartRoot(context.Background(), "span", http.Header{})
+	span.End()
+	tracer.Close()
+	if exportErr == nil {
+		t.Errorf("Close() export error is not reported for 400 response")
+	}
+}
+
+// memoryExporter keeps spans after shutdown unlike tracetest.InMemoryExporter
+type memoryExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func newMemoryExporter() memoryExporter {
+	return memoryExporter{tracetest.NewInMemoryExporter()}
+}
+
+func (e memoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestTracer_Close(t *testing.T) {
+	exporter := newMemoryExporter()
+	tracer := NewTracer(exporter, "image-cutter", 1, false, 10, nil)
+	_, root := tracer.StartRoot(context.Background(), "root", http.Header{})
+	root.End()
+	root.End() // second End is ignored
+	tracer.Close()
+	tracer.Close()
+
+	if len(exporter.GetSpans()) != 1 {
+		t.Fatalf("Close() exported %v spans, want 1", len(exporter.GetSpans()))
+	}
+}
@@ -0,0 +1,34 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Start starts child span of current span of ctx. If ctx has no span, span is not recorded,
+// so code may be traced without tracer
+func Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	return StartKind(ctx, name, trace.SpanKindInternal)
+}
+
+// StartKind starts child span of given kind with tracer provider of current span
+func StartKind(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(scopeName).Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// SetError marks span as failed, nil error is ignored
+func SetError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Inject sets W3C traceparent header of current span of ctx
+func Inject(ctx context.Context, header http.Header) {
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+// Tracer starts spans of incoming requests with OpenTelemetry SDK and exports finished spans
+// in batches from background goroutine. If queue is full new spans are dropped
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+const (
+	scopeName     = "ImageCutter"
+	batchSize     = 100
+	batchInterval = 5 * time.Second
+)
+
+// Sampler samples new traces with sampleRatio. Sampled traceparent of client may only lower it
+// unless trustParent is set, so clients cannot force export of every request.
+// Not sampled traceparent is never exported, child spans follow their local parent
+func Sampler(sampleRatio float64, trustParent bool) sdktrace.Sampler {
+	root := sdktrace.TraceIDRatioBased(sampleRatio)
+	remoteSampled := root
+	if trustParent {
+		remoteSampled = sdktrace.AlwaysSample()
+	}
+	return sdktrace.ParentBased(root,
+		sdktrace.WithRemoteParentSampled(remoteSampled),
+		sdktrace.WithRemoteParentNotSampled(sdktrace.NeverSample()),
+	)
+}
+
+// NewTracer creates tracer of service which exports spans with exporter, see Sampler for sampling.
+// Export errors are passed to onError if it is not nil
+func NewTracer(exporter sdktrace.SpanExporter, serviceName string, sampleRatio float64, trustParent bool, queueSize int, onError func(error)) *Tracer {
+	if onError != nil {
+		exporter = &errorExporter{SpanExporter: exporter, onError: onError}
+	}
+	options := []sdktrace.BatchSpanProcessorOption{sdktrace.WithBatchTimeout(batchInterval), sdktrace.WithMaxExportBatchSize(batchSize)}
+	if queueSize > 0 {
+		options = append(options, sdktrace.WithMaxQueueSize(queueSize))
+		if queueSize < batchSize {
+			options = append(options, sdktrace.WithMaxExportBatchSize(queueSize))
+		}
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, options...),
+		sdktrace.WithSampler(Sampler(sampleRatio, trustParent)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	return &Tracer{provider: provider, tracer: provider.Tracer(scopeName)}
+}
+
+// StartRoot starts server span of incoming request. Trace of client is continued
+// if header has valid W3C traceparent, otherwise new trace is started
+func (t *Tracer) StartRoot(ctx context.Context, name string, header http.Header) (context.Context, trace.Span) {
+	ctx = propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(header))
+	return t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// Close exports queued spans and stops background goroutine
+func (t *Tracer) Close() {
+	_ = t.provider.Shutdown(context.Background())
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"testing"
+)
+
+const (
+	sampledParent    = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	notSampledParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+)
+
+func TestTracer_StartRoot(t *testing.T) {
+	tests := []struct {
+		name        string
+		ratio       float64
+		trustParent bool
+		traceparent string
+		wantSampled bool
+	}{
+		{name: "New trace is sampled", ratio: 1, wantSampled: true},
+		{name: "New trace is not sampled", ratio: 0, wantSampled: false},
+		{name: "Sampled parent with full ratio", ratio: 1, traceparent: sampledParent, wantSampled: true},
+		{name: "Sampled parent does not bypass ratio", ratio: 0, traceparent: sampledParent, wantSampled: false},
+		{name: "Trusted sampled parent bypasses ratio", ratio: 0, trustParent: true, traceparent: sampledParent, wantSampled: true},
+		{name: "Not sampled parent", ratio: 1, traceparent: notSampledParent, wantSampled: false},
+		{name: "Trusted not sampled parent", ratio: 1, trustParent: true, traceparent: notSampledParent, wantSampled: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := newMemoryExporter()
+			tracer := NewTracer(exporter, "image-cutter", tt.ratio, tt.trustParent, 10, nil)
+			header := http.Header{}
+			if tt.traceparent != "" {
+				header.Set("traceparent", tt.traceparent)
+			}
+			ctx, span := tracer.StartRoot(context.Background(), "GET /crop", header)
+			sc := span.SpanContext()
+			if sc.IsSampled() != tt.wantSampled {
+				t.Errorf("StartRoot() sampled = %v, want %v", sc.IsSampled(), tt.wantSampled)
+			}
+			if !sc.IsValid() {
+				t.Errorf("StartRoot() span context %+v is not valid", sc)
+			}
+			if !trace.SpanContextFromContext(ctx).Equal(sc) {
+				t.Errorf("StartRoot() span is not in context")
+			}
+
+			_, child := Start(ctx, "cropper.Crop")
+			if child.SpanContext().TraceID() != sc.TraceID() || child.SpanContext().IsSampled() != sc.IsSampled() {
+				t.Errorf("Start() child %+v does not belong to root %+v", child.SpanContext(), sc)
+			}
+			child.End()
+			span.End()
+			tracer.Close()
+
+			spans := exporter.GetSpans()
+			if !tt.wantSampled {
+				if len(spans) != 0 {
+					t.Errorf("Close() exported %v spans of not sampled trace", len(spans))
+				}
+				return
+			}
+			if len(spans) != 2 {
+				t.Fatalf("Close() exported %v spans, want 2", len(spans))
+			}
+			gotChild, gotRoot := spans[0], spans[1]
+			if gotChild.Parent.SpanID() != sc.SpanID() {
+				t.Errorf("Start() child parent = %v, want %v", gotChild.Parent.SpanID(), sc.SpanID())
+			}
+			if gotRoot.SpanKind != trace.SpanKindServer {
+				t.Errorf("StartRoot() kind = %v, want server", gotRoot.SpanKind)
+			}
+			if tt.traceparent != "" {
+				if sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || gotRoot.Parent.SpanID().String() != "00f067aa0ba902b7" {
+					t.Errorf("StartRoot() does not continue trace of parent")
+				}
+			} else if gotRoot.Parent.IsValid() {
+				t.Errorf("StartRoot() new trace has parent %v", gotRoot.Parent.SpanID())
+			}
+		})
+	}
+}
+
+func TestStart_WithoutTracer(t *testing.T) {
+	ctx, span := Start(context.Background(), "cropper.Crop")
+	if span.IsRecording() || span.SpanContext().IsValid() {
+		t.Errorf("Start() without tracer span is recorded")
+	}
+	SetError(span, nil)
+	span.End()
+
+	header := http.Header{}
+	Inject(ctx, header)
+	if header.Get("traceparent") != "" {
+		t.Errorf("Inject() without span traceparent = %v", header.Get("traceparent"))
+	}
+}
+
+func TestTracer_Inject(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		wantParent  bool
+	}{
+		{name: "Sampled", traceparent: sampledParent, wantParent: true},
+		{name: "Future version with extra fields", traceparent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantParent: true},
+		{name: "Version 00 with extra fields", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantParent: false},
+		{name: "Forbidden version", traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantParent: false},
+		{name: "Zero trace id", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantParent: false},
+		{name: "Zero span id", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantParent: false},
+		{name: "Short trace id", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", wantParent: false},
+		{name: "Not hex", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", wantParent: false},
+		{name: "Missing", traceparent: "", wantParent: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := NewTracer(newMemoryExporter(), "image-cutter", 1, true, 10, nil)
+			defer tracer.Close()
+			header := http.Header{}
+			if tt.traceparent != "" {
+				header.Set("traceparent", tt.traceparent)
+			}
+			ctx, span := tracer.StartRoot(context.Background(), "root", header)
+			defer span.End()
+			gotParent := span.SpanContext().TraceID().String() == "4bf92f3577b34da6a3ce929d0e0e4736"
+			if gotParent != tt.wantParent {
+				t.Errorf("StartRoot() continues trace = %v, want %v", gotParent, tt.wantParent)
+			}
+
+			// Outgoing request continues trace with span id of current span
+			ctx, client := StartKind(ctx, "HTTP GET", trace.SpanKindClient)
+			defer client.End()
+			out := http.Header{}
+			Inject(ctx, out)
+			want := "00-" + span.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"
+			if out.Get("traceparent") != want {
+				t.Errorf("Inject() traceparent = %v, want %v", out.Get("traceparent"), want)
+			}
+		})
+	}
+}